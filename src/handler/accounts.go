@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Account states
+const (
+	accountPending = "pending"
+	accountActive  = "active"
+)
+
+// pendingAccountTTL is how long a new account may wait for its verification
+const pendingAccountTTL = 24 * time.Hour
+
+var (
+	// zoomTokenURL is where Zoom issues access tokens, it is replaced in tests
+	zoomTokenURL = "https://zoom.us/oauth/token"
+	zoomClient   = &http.Client{Timeout: 10 * time.Second}
+
+	errZoomCredentials = errors.New("credentials rejected by Zoom")
+)
+
+// verifyZoomAccount asks Zoom for an access token with the credentials of a Server-to-Server OAuth
+// app. Zoom only issues it if the app belongs to the given account, so unlike a webhook signed with
+// a secret token the registrant chose, it proves that they manage the account. The token is not kept.
+func verifyZoomAccount(accountID, clientID, clientSecret string) error {
+	query := url.Values{"grant_type": {"account_credentials"}, "account_id": {accountID}}
+	req, err := http.NewRequest(http.MethodPost, zoomTokenURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(clientID, clientSecret)
+	resp, err := zoomClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: status %d", errZoomCredentials, resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return fmt.Errorf("%w: no access token", errZoomCredentials)
+	}
+	return nil
+}
+
+// activateAccount marks a pending account as verified
+func activateAccount(accountID string) error {
+	_, err := appState.DB.Exec("UPDATE accounts SET status = ? WHERE account_id = ?", accountActive, accountID)
+	if err != nil {
+		log.Printf("Failed to activate account %s: %v", accountID, err)
+		return err
+	}
+	log.Printf("Activated account: %s", accountID)
+	return nil
+}
+
+// expirePendingAccounts deletes accounts that were not verified in time
+func expirePendingAccounts() error {
+	cutoff := time.Now().Add(-pendingAccountTTL).Unix()
+	result, err := appState.DB.Exec("DELETE FROM accounts WHERE status = ? AND created_at < ?", accountPending, cutoff)
+	if err != nil {
+		return err
+	}
+	if expired, _ := result.RowsAffected(); expired > 0 {
+		log.Printf("Removed %d unverified accounts", expired)
+	}
+	return nil
+}
+
+// cleanupPendingAccounts periodically removes expired pending accounts
+func cleanupPendingAccounts() {
+	for {
+		time.Sleep(time.Hour)
+		if err := expirePendingAccounts(); err != nil {
+			log.Printf("Failed to remove unverified accounts: %v", err)
+		}
+	}
+}
+
+// verifyAccountHandler activates a pending account once Zoom accepts the credentials of an app of
+// the account. The viewer password chosen at registration must be entered, so that the owner of
+// the Zoom account cannot activate a registration somebody else made with their account ID.
+func verifyAccountHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ip := clientIP(r)
+	if !loginLimits.allow(ip, clock()) {
+		blockedLoginAttempts.Add(1)
+		renderError(w, "Zu viele Fehlversuche. Bitte versuchen Sie es später erneut.")
+		return
+	}
+	if err := expirePendingAccounts(); err != nil {
+		renderError(w, fmt.Sprintf("Fehler beim Bestätigen des Kontos: %v", err))
+		return
+	}
+
+	accountID := r.FormValue("account_id")
+	var viewerHash, status string
+	err := appState.DB.QueryRow("SELECT viewer_hash, status FROM accounts WHERE account_id = ?", accountID).Scan(&viewerHash, &status)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		renderError(w, fmt.Sprintf("Fehler beim Bestätigen des Kontos: %v", err))
+		return
+	}
+	if err != nil || !verifyViewerPassword(r.FormValue("viewer_password"), viewerHash) {
+		loginLimits.failure(ip, clock())
+		renderError(w, "Konto-ID oder Zugangskennwort ist falsch.")
+		return
+	}
+	loginLimits.success(ip)
+	if status == accountActive {
+		renderTemplate(w, pageData{InfoMessage: "Das Konto ist bereits bestätigt."})
+		return
+	}
+
+	if err := verifyZoomAccount(accountID, r.FormValue("client_id"), r.FormValue("client_secret")); err != nil {
+		log.Printf("Verification of account %s failed: %v", accountID, err)
+		renderError(w, "Zoom hat die Zugangsdaten für diese Konto-ID nicht bestätigt.")
+		return
+	}
+	if err := activateAccount(accountID); err != nil {
+		renderError(w, fmt.Sprintf("Fehler beim Bestätigen des Kontos: %v", err))
+		return
+	}
+	renderTemplate(w, pageData{InfoMessage: "Konto bestätigt. Sie können sich jetzt anmelden."})
+}
+
+// updateAccountHandler rotates the secret token and/or changes the viewer password of an account.
+// The current viewer password must be entered again.
+func updateAccountHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	accountID, errorMessage := authenticateViewer(r, r.FormValue("current_password"))
+	if accountID == "" {
+		renderError(w, errorMessage)
+		return
+	}
+
+	secretToken := r.FormValue("secret_token")
+	viewerPassword := r.FormValue("viewer_password")
+	if secretToken == "" && viewerPassword == "" {
+		renderError(w, "Bitte einen neuen Secret Token oder ein neues Viewer-Passwort angeben.")
+		return
+	}
+	if (secretToken != "" && len(secretToken) < 15) || (viewerPassword != "" && len(viewerPassword) < 15) {
+		renderError(w, "Secret Token und Viewer-Passwort müssen mindestens 15 Zeichen lang sein.")
+		return
+	}
+
+	var assignments []string
+	var args []any
+	if secretToken != "" {
+		encryptedToken, err := encryptSecret(accountID, secretToken)
+		if err != nil {
+			renderError(w, fmt.Sprintf("Fehler beim Aktualisieren des Kontos: %v", err))
+			return
+		}
+		assignments = append(assignments, "secret_token_enc = ?")
+		args = append(args, encryptedToken)
+	}
+	if viewerPassword != "" {
+		viewerHash, err := hashViewerPassword(viewerPassword)
+		if err != nil {
+			renderError(w, fmt.Sprintf("Fehler beim Aktualisieren des Kontos: %v", err))
+			return
+		}
+		assignments = append(assignments, "viewer_lookup = ?", "viewer_hash = ?")
+		args = append(args, viewerLookup(viewerPassword), viewerHash)
+	}
+	args = append(args, accountID)
+
+	_, err := appState.DB.Exec("UPDATE accounts SET "+strings.Join(assignments, ", ")+" WHERE account_id = ?", args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			renderError(w, "Das Viewer-Passwort ist nicht sicher genug.")
+		} else {
+			renderError(w, fmt.Sprintf("Fehler beim Aktualisieren des Kontos: %v", err))
+		}
+		return
+	}
+
+	if viewerPassword != "" {
+		bus.AccountChanged(accountID, true)
+	}
+	log.Printf("Updated account: %s", accountID)
+	clearSession(w, r)
+	renderTemplate(w, pageData{InfoMessage: "Konto aktualisiert. Bitte melden Sie sich erneut an."})
+}
+
+// deleteAccountHandler removes an account together with its cached credentials, meetings and viewer
+// connections. The current viewer password and the account ID must be entered for confirmation.
+func (h *handlers) deleteAccountHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	accountID, errorMessage := authenticateViewer(r, r.FormValue("current_password"))
+	if accountID == "" {
+		renderError(w, errorMessage)
+		return
+	}
+	if r.FormValue("confirm_account_id") != accountID {
+		renderError(w, "Die eingegebene Konto-ID stimmt nicht überein.")
+		return
+	}
+
+	if _, err := appState.DB.Exec("DELETE FROM accounts WHERE account_id = ?", accountID); err != nil {
+		renderError(w, fmt.Sprintf("Fehler beim Löschen des Kontos: %v", err))
+		return
+	}
+
+	bus.AccountChanged(accountID, true)
+	if err := h.store.Forget(accountID); err != nil {
+		log.Printf("Failed to remove meetings of account %s: %v", accountID, err)
+	}
+	rewriteSnapshot(h.store)
+	log.Printf("Deleted account: %s", accountID)
+	clearSession(w, r)
+	renderTemplate(w, pageData{InfoMessage: "Konto gelöscht."})
+}
+
+// forgetViewerPasswords removes all cached viewer password lookups of an account
+func forgetViewerPasswords(accountID string) {
+	appState.PasswordMutex.Lock()
+	defer appState.PasswordMutex.Unlock()
+	for lookup, cachedAccountID := range appState.PasswordToAccountID {
+		if cachedAccountID == accountID {
+			delete(appState.PasswordToAccountID, lookup)
+		}
+	}
+}
+
+// forgetRetention removes the cached retention policy of an account, it is loaded again when needed
+func forgetRetention(accountID string) {
+	appState.PasswordMutex.Lock()
+	defer appState.PasswordMutex.Unlock()
+	delete(appState.Retention, accountID)
+}
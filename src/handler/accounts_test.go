@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyAccount(t *testing.T) {
+	db := newTestDB(t)
+	addTestAccount(t, db, "acc", "viewerpassword123", defaultRetention)
+	if _, err := db.Exec("UPDATE accounts SET status = ? WHERE account_id = ?", accountPending, "acc"); err != nil {
+		t.Fatal(err)
+	}
+	previousTemplate := tmpl
+	tmpl = template.Must(template.New("content.gohtml").Parse("{{ .InfoMessage }}{{ .ErrorMessage }}"))
+	t.Cleanup(func() { tmpl = previousTemplate })
+
+	// Zoom only issues tokens to the apps of the account
+	zoom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		query := r.URL.Query()
+		if clientID != "client" || clientSecret != "secret" || query.Get("grant_type") != "account_credentials" || query.Get("account_id") != "acc" {
+			http.Error(w, `{"reason":"Invalid client_id or client_secret"}`, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token":"token","token_type":"bearer"}`))
+	}))
+	t.Cleanup(zoom.Close)
+	previousURL := zoomTokenURL
+	zoomTokenURL = zoom.URL
+	t.Cleanup(func() { zoomTokenURL = previousURL })
+
+	store := newMemoryStore()
+	h := &handlers{store: store}
+	joined := func() bool {
+		found := false
+		store.View("acc", func(meetings map[string]*MeetingData) {
+			_, found = meetings["m1"]
+		})
+		return found
+	}
+	status := func() string {
+		var status string
+		if err := db.QueryRow("SELECT status FROM accounts WHERE account_id = ?", "acc").Scan(&status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+	ts := time.Now().UnixMilli()
+
+	// Whoever registered the account chose the secret token, so their signed webhooks verify nothing
+	if code := sendWebhook(t, h, webhookPayload("acc", "meeting.participant_joined", "m1", "u1", "Alice", ts)); code != http.StatusOK {
+		t.Fatalf("webhook of pending account: got %d", code)
+	}
+	if joined() || status() != accountPending {
+		t.Fatalf("webhook of pending account: applied %v, status %s", joined(), status())
+	}
+
+	tests := []struct {
+		name           string
+		viewerPassword string
+		clientSecret   string
+		want           string
+	}{
+		{"wrong viewer password", "otherpassword123", "secret", accountPending},
+		{"credentials of another account", "viewerpassword123", "guessed", accountPending},
+		{"credentials of the account", "viewerpassword123", "secret", accountActive},
+	}
+	now := time.Now()
+	setClock(t, func() time.Time { return now })
+	for _, tt := range tests {
+		// Wait for the delay after the failed attempt before
+		now = now.Add(time.Hour)
+		form := url.Values{"account_id": {"acc"}, "viewer_password": {tt.viewerPassword}, "client_id": {"client"}, "client_secret": {tt.clientSecret}}
+		r := httptest.NewRequest(http.MethodPost, "/account/verify", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		verifyAccountHandler(httptest.NewRecorder(), r, nil)
+		if got := status(); got != tt.want {
+			t.Errorf("%s: status %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if code := sendWebhook(t, h, webhookPayload("acc", "meeting.participant_joined", "m1", "u1", "Alice", ts+1)); code != http.StatusOK {
+		t.Fatalf("webhook of verified account: got %d", code)
+	}
+	if !joined() {
+		t.Error("webhook of verified account was not applied")
+	}
+}
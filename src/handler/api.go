@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// The JSON API is documented in openapi.yaml, keep both in sync
+
+type apiMeeting struct {
+	UUID             string    `json:"uuid"`
+	ID               string    `json:"id"`
+	Topic            string    `json:"topic"`
+	ParticipantCount int       `json:"participant_count"`
+	WaitingCount     int       `json:"waiting_count"`
+	Ended            bool      `json:"ended"`
+	LastUpdated      time.Time `json:"last_updated"`
+}
+
+type apiParticipant struct {
+	Name            string     `json:"name"`
+	Email           string     `json:"email,omitempty"`
+	Present         bool       `json:"present"`
+	BreakoutRoom    string     `json:"breakout_room,omitempty"`
+	FirstJoin       time.Time  `json:"first_join"`
+	LastLeave       *time.Time `json:"last_leave,omitempty"`
+	Rejoins         int        `json:"rejoins"`
+	MinutesAttended int        `json:"minutes_attended"`
+}
+
+type apiMeetingDetail struct {
+	apiMeeting
+	Participants  []apiParticipant `json:"participants"`
+	Waiting       []string         `json:"waiting"`
+	BreakoutRooms []BreakoutRoom   `json:"breakout_rooms"`
+}
+
+type apiCounts struct {
+	Meetings     int `json:"meetings"`
+	Participants int `json:"participants"`
+	Waiting      int `json:"waiting"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+// apiAccount authenticates an API request with a bearer token, which is the viewer password,
+// or with the session cookie of the web interface
+func apiAccount(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		accountID, errorMessage := authenticateToken(r, token)
+		if accountID == "" {
+			writeJSON(w, http.StatusUnauthorized, apiError{errorMessage})
+			return "", false
+		}
+		return accountID, true
+	}
+	if accountID, authenticated := sessionAccount(r); authenticated {
+		return accountID, true
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="zoomParticipants"`)
+	writeJSON(w, http.StatusUnauthorized, apiError{"Nicht angemeldet."})
+	return "", false
+}
+
+// newAPIMeeting summarizes a meeting. The caller must hold the account mutex.
+func newAPIMeeting(uuid string, meeting *MeetingData) apiMeeting {
+	return apiMeeting{
+		UUID:             uuid,
+		ID:               meeting.ID,
+		Topic:            meeting.Topic,
+		ParticipantCount: len(meeting.presentNames()),
+		WaitingCount:     len(meeting.Waiting),
+		Ended:            meeting.Ended,
+		LastUpdated:      meeting.LastUpdated,
+	}
+}
+
+// apiListMeetingsHandler lists all meetings of the account, most recently updated first
+func (h *handlers) apiListMeetingsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	accountID, ok := apiAccount(w, r)
+	if !ok {
+		return
+	}
+
+	list := []apiMeeting{}
+	h.store.View(accountID, func(meetings map[string]*MeetingData) {
+		for uuid, meeting := range meetings {
+			list = append(list, newAPIMeeting(uuid, meeting))
+		}
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastUpdated.After(list[j].LastUpdated)
+	})
+	writeJSON(w, http.StatusOK, map[string][]apiMeeting{"meetings": list})
+}
+
+// apiGetMeetingHandler returns one meeting with its participants. The UUID may contain slashes.
+func (h *handlers) apiGetMeetingHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	accountID, ok := apiAccount(w, r)
+	if !ok {
+		return
+	}
+	meetingUUID := strings.TrimPrefix(ps.ByName("uuid"), "/")
+
+	var detail *apiMeetingDetail
+	h.store.View(accountID, func(meetings map[string]*MeetingData) {
+		if meeting, exists := meetings[meetingUUID]; exists {
+			detail = newAPIMeetingDetail(meetingUUID, meeting, time.Now())
+		}
+	})
+	if detail == nil {
+		writeJSON(w, http.StatusNotFound, apiError{"Meeting nicht gefunden."})
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// newAPIMeetingDetail describes a meeting with its participants. The meeting must not change meanwhile.
+func newAPIMeetingDetail(meetingUUID string, meeting *MeetingData, now time.Time) *apiMeetingDetail {
+	participants := make([]apiParticipant, 0, len(meeting.Participants))
+	for key, participant := range meeting.Participants {
+		entry := apiParticipant{
+			Name:            participant.Name,
+			Email:           participant.Email,
+			Present:         participant.Present(),
+			FirstJoin:       participant.FirstJoin(),
+			Rejoins:         participant.Rejoins(),
+			MinutesAttended: int(math.Round(participant.TotalAttended(now).Minutes())),
+		}
+		if lastLeave := participant.LastLeave(); !lastLeave.IsZero() {
+			entry.LastLeave = &lastLeave
+		}
+		if roomUUID, inRoom := meeting.Rooms[key]; inRoom {
+			entry.BreakoutRoom = meeting.roomName(roomUUID)
+		}
+		participants = append(participants, entry)
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].Name < participants[j].Name
+	})
+
+	return &apiMeetingDetail{
+		apiMeeting:    newAPIMeeting(meetingUUID, meeting),
+		Participants:  participants,
+		Waiting:       sortedNames(meeting.Waiting),
+		BreakoutRooms: meeting.breakoutRooms(),
+	}
+}
+
+// apiCountsHandler returns the number of running meetings and their participants
+func (h *handlers) apiCountsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	accountID, ok := apiAccount(w, r)
+	if !ok {
+		return
+	}
+
+	var counts apiCounts
+	h.store.View(accountID, func(meetings map[string]*MeetingData) {
+		for _, meeting := range meetings {
+			if meeting.Ended {
+				continue
+			}
+			counts.Meetings++
+			counts.Participants += len(meeting.presentNames())
+			counts.Waiting += len(meeting.Waiting)
+		}
+	})
+	writeJSON(w, http.StatusOK, counts)
+}
@@ -0,0 +1,312 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// apiRequest sends a GET request with the bearer token to the API and returns the response
+func apiRequest(t *testing.T, router http.Handler, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestBearerTokenGlobalLimit(t *testing.T) {
+	db := newTestDB(t)
+	addTestAccount(t, db, "acc", "viewerpassword123", defaultRetention)
+	router := httprouter.New()
+	SetupHandlers(router, db, newMemoryStore())
+	now := time.Unix(1_700_000_000, 0)
+	setClock(t, func() time.Time { return now })
+
+	// Polling with a valid token must not use up the password checks of the login form
+	for i := 0; i < 2*loginGlobalBurst; i++ {
+		if w := apiRequest(t, router, "/api/v1/counts", "viewerpassword123"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, w.Code)
+		}
+	}
+	if !loginLimits.allow("192.0.2.1", now) {
+		t.Fatal("valid tokens exhausted the global limit")
+	}
+
+	// Wrong tokens are delayed like failed logins
+	if w := apiRequest(t, router, "/api/v1/counts", "wrongpassword"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: got %d", w.Code)
+	}
+	if w := apiRequest(t, router, "/api/v1/counts", "viewerpassword123"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("token during backoff: got %d", w.Code)
+	}
+	now = now.Add(loginBackoffBase)
+	if w := apiRequest(t, router, "/api/v1/counts", "viewerpassword123"); w.Code != http.StatusOK {
+		t.Fatalf("token after backoff: got %d", w.Code)
+	}
+}
+
+// yamlLine is a line of a YAML document without its indentation
+type yamlLine struct {
+	indent int
+	text   string
+}
+
+// parseYAML parses the subset of YAML used by openapi.yaml: nested maps, block and flow lists,
+// quoted and plain scalars and folded strings. All scalars are returned as strings.
+func parseYAML(t *testing.T, path string) map[string]any {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []yamlLine
+	for _, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimLeft(line, " ")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		lines = append(lines, yamlLine{len(line) - len(text), strings.TrimRight(text, " ")})
+	}
+	document, _ := parseYAMLNode(lines, 0, 0)
+	return document.(map[string]any)
+}
+
+// parseYAMLNode parses the map or list starting at line i with the given indentation and returns
+// the index of the first line after it
+func parseYAMLNode(lines []yamlLine, i, indent int) (any, int) {
+	if strings.HasPrefix(lines[i].text, "- ") {
+		list := []any{}
+		for i < len(lines) && lines[i].indent == indent && strings.HasPrefix(lines[i].text, "- ") {
+			item := strings.TrimPrefix(lines[i].text, "- ")
+			if !strings.Contains(item, ": ") && !strings.HasSuffix(item, ":") {
+				list = append(list, yamlScalar(item))
+				i++
+				continue
+			}
+			// A map item continues on the following lines, aligned with its first key
+			lines[i] = yamlLine{indent + 2, item}
+			var value any
+			value, i = parseYAMLNode(lines, i, indent+2)
+			list = append(list, value)
+		}
+		return list, i
+	}
+
+	node := map[string]any{}
+	for i < len(lines) && lines[i].indent == indent {
+		key, value, _ := strings.Cut(lines[i].text, ":")
+		key = strings.Trim(key, `"`)
+		value = strings.TrimSpace(value)
+		i++
+		switch {
+		case value == ">-":
+			var folded []string
+			for ; i < len(lines) && lines[i].indent > indent; i++ {
+				folded = append(folded, lines[i].text)
+			}
+			node[key] = strings.Join(folded, " ")
+		case value != "":
+			node[key] = yamlScalar(value)
+		case i < len(lines) && lines[i].indent > indent:
+			node[key], i = parseYAMLNode(lines, i, lines[i].indent)
+		default:
+			node[key] = nil
+		}
+	}
+	return node, i
+}
+
+// yamlScalar parses a plain, quoted or flow list value
+func yamlScalar(value string) any {
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		list := []any{}
+		for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+		return list
+	}
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		return unquoted
+	}
+	return value
+}
+
+// schemaValidator checks JSON values against the schemas of an OpenAPI document. It covers the
+// keywords used by openapi.yaml for responses: $ref, allOf, type, format, nullable, required,
+// properties and items.
+type schemaValidator struct {
+	document map[string]any
+}
+
+// resolve follows a local reference like #/components/schemas/Meeting
+func (s schemaValidator) resolve(ref string) map[string]any {
+	var node any = s.document
+	for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		node = node.(map[string]any)[strings.ReplaceAll(strings.ReplaceAll(key, "~1", "/"), "~0", "~")]
+	}
+	return node.(map[string]any)
+}
+
+// validate returns a description of every mismatch between value and schema
+func (s schemaValidator) validate(schema map[string]any, value any, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		return s.validate(s.resolve(ref), value, path)
+	}
+	var problems []string
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, part := range allOf {
+			problems = append(problems, s.validate(part.(map[string]any), value, path)...)
+		}
+	}
+	if value == nil {
+		if schema["nullable"] == "true" || schema["type"] == nil {
+			return problems
+		}
+		return append(problems, path+": null is not allowed")
+	}
+
+	mismatch := func() []string {
+		return append(problems, fmt.Sprintf("%s: %T is not of type %v", path, value, schema["type"]))
+	}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return mismatch()
+		}
+		required, _ := schema["required"].([]any)
+		for _, key := range required {
+			if _, exists := object[key.(string)]; !exists {
+				problems = append(problems, fmt.Sprintf("%s: missing required field %s", path, key))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for key, property := range properties {
+			if field, exists := object[key]; exists {
+				problems = append(problems, s.validate(property.(map[string]any), field, path+"."+key)...)
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return mismatch()
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range array {
+				problems = append(problems, s.validate(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return mismatch()
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, text); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a date-time", path, text))
+			}
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != math.Trunc(number) {
+			return mismatch()
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return mismatch()
+		}
+	}
+	return problems
+}
+
+// responseSchema returns the schema of a successful JSON response of an operation
+func (s schemaValidator) responseSchema(path string) map[string]any {
+	operation := s.document["paths"].(map[string]any)[path].(map[string]any)["get"].(map[string]any)
+	response := operation["responses"].(map[string]any)["200"].(map[string]any)
+	return response["content"].(map[string]any)["application/json"].(map[string]any)["schema"].(map[string]any)
+}
+
+func TestAPIMatchesOpenAPI(t *testing.T) {
+	db := newTestDB(t)
+	addTestAccount(t, db, "acc", "viewerpassword123", defaultRetention)
+	store := newMemoryStore()
+	router := httprouter.New()
+	SetupHandlers(router, db, store)
+	h := &handlers{store: store}
+
+	// A meeting with a participant in a breakout room, one who left and one in the waiting room
+	ts := time.Now().UnixMilli()
+	alice := webhookPayload("acc", "meeting.participant_joined", "m1/x==", "u1", "Alice", ts)
+	alice.Payload.Object.Participant.Email = "alice@example.com"
+	room := webhookPayload("acc", "meeting.participant_joined_breakout_room", "m1/x==", "b1", "Alice", ts+1)
+	room.Payload.Object.BreakoutRoomUUID = "r1"
+	room.Payload.Object.Participant.ParentUserID = "u1"
+	events := []ZoomWebhookPayload{
+		alice,
+		webhookPayload("acc", "meeting.participant_joined", "m1/x==", "u2", "Bob", ts+2),
+		webhookPayload("acc", "meeting.participant_left", "m1/x==", "u2", "Bob", ts+3),
+		webhookPayload("acc", "meeting.participant_joined_waiting_room", "m1/x==", "u3", "Carol", ts+4),
+		room,
+		webhookPayload("acc", "meeting.participant_joined", "m2", "u4", "Dave", ts+5),
+		webhookPayload("acc", "meeting.ended", "m2", "", "", ts+6),
+	}
+	for _, payload := range events {
+		if code := sendWebhook(t, h, payload); code != http.StatusOK {
+			t.Fatalf("webhook %s: got %d", payload.Event, code)
+		}
+	}
+
+	validator := schemaValidator{parseYAML(t, "../../openapi.yaml")}
+	// The validator must notice a response that does not match
+	invalid := map[string]any{"uuid": "m1", "participant_count": "3", "participants": []any{map[string]any{"name": "Alice"}}}
+	if problems := validator.validate(validator.responseSchema("/meetings/{uuid}"), invalid, "invalid"); len(problems) < 5 {
+		t.Fatalf("invalid response: got only %v", problems)
+	}
+	tests := []struct {
+		name   string
+		url    string
+		schema string
+	}{
+		{"meetings", "/api/v1/meetings", "/meetings"},
+		{"meeting", "/api/v1/meetings/m1/x==", "/meetings/{uuid}"},
+		{"escaped meeting", "/api/v1/meetings/" + url.PathEscape("m1/x=="), "/meetings/{uuid}"},
+		{"ended meeting", "/api/v1/meetings/m2", "/meetings/{uuid}"},
+		{"counts", "/api/v1/counts", "/counts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := apiRequest(t, router, tt.url, "viewerpassword123")
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", w.Code, w.Body)
+			}
+			var body any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range validator.validate(validator.responseSchema(tt.schema), body, "response") {
+				t.Error(problem)
+			}
+		})
+	}
+
+	// The errors are described by the Error schema
+	w := apiRequest(t, router, "/api/v1/meetings/unknown", "viewerpassword123")
+	var body any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusNotFound {
+		t.Fatalf("unknown meeting: got %d, %v", w.Code, err)
+	}
+	for _, problem := range validator.validate(validator.resolve("#/components/schemas/Error"), body, "error") {
+		t.Error(problem)
+	}
+}
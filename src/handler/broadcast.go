@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+)
+
+// Number of updates kept per meeting for viewers resuming a stream
+const historySize = 256
+
+// update is one change of a meeting for its viewers, numbered per meeting
+type update struct {
+	seq  uint64
+	data []byte // Protocol v1 message
+	v2   []byte // Protocol v2 message
+}
+
+// payload returns the message in the given protocol version
+func (u update) payload(version int) []byte {
+	if version == protocolV2 {
+		return u.v2
+	}
+	return u.data
+}
+
+// broadcastData announces a change to all WebSocket and SSE viewers of a meeting. The v2 message
+// receives its sequence number here. It must be called within Store.Update, which keeps sequence
+// numbers in order and publishes the change once it is saved.
+func broadcastData(accountID, meetingUUID string, meeting *MeetingData, data []byte, v2 map[string]interface{}) {
+	if meeting == nil {
+		bus.Publish(accountID, meetingUUID, update{data: data, v2: encodeV2(meetingUUID, 0, v2)})
+		return
+	}
+	meeting.unpublished = append(meeting.unpublished, meeting.record(meetingUUID, data, v2))
+}
+
+// publishChanges publishes the updates recorded while Store.Update changed the meetings, given as
+// they were before and after the change. Meetings the update removed still announce their last
+// changes, such as the end of the meeting. The caller must hold the account mutex.
+func publishChanges(accountID string, before, after map[string]*MeetingData) {
+	for _, meetings := range []map[string]*MeetingData{before, after} {
+		for meetingUUID, meeting := range meetings {
+			for _, u := range meeting.unpublished {
+				bus.Publish(accountID, meetingUUID, u)
+			}
+			meeting.unpublished = nil
+		}
+	}
+}
+
+// forwardChanges writes the updates recorded while Store.Update changed the meetings for the other
+// instances, in the transaction saving the change. The caller must hold the account mutex.
+func forwardChanges(tx *sql.Tx, accountID string, before, after map[string]*MeetingData) error {
+	// Meetings that were kept appear in both maps
+	forwarded := make(map[*MeetingData]bool)
+	for _, meetings := range []map[string]*MeetingData{before, after} {
+		for meetingUUID, meeting := range meetings {
+			if forwarded[meeting] {
+				continue
+			}
+			forwarded[meeting] = true
+			for _, u := range meeting.unpublished {
+				if err := bus.Forward(tx, accountID, meetingUUID, u); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// record numbers a change and keeps it for resuming viewers
+func (m *MeetingData) record(meetingUUID string, data []byte, v2 map[string]interface{}) update {
+	m.Seq++
+	u := update{seq: m.Seq, data: data, v2: encodeV2(meetingUUID, m.Seq, v2)}
+	if len(m.history) == historySize {
+		m.history = append(m.history[:0], m.history[1:]...)
+	}
+	m.history = append(m.history, u)
+	return u
+}
+
+// updatesSince returns the updates after seq, or false if some of them are no longer kept
+func (m *MeetingData) updatesSince(seq uint64) ([]update, bool) {
+	if seq > m.Seq {
+		return nil, false
+	}
+	if seq == m.Seq {
+		return nil, true
+	}
+	if len(m.history) == 0 || m.history[0].seq > seq+1 {
+		return nil, false
+	}
+	return m.history[seq+1-m.history[0].seq:], true
+}
+
+// resetMessage describes the complete state of a meeting, replacing whatever the viewer shows.
+// The caller must hold the account mutex.
+func resetMessage(meeting *MeetingData) []byte {
+	var names, waiting []string
+	var rooms []BreakoutRoom
+	if meeting != nil {
+		names = meeting.presentNames()
+		waiting = sortedNames(meeting.Waiting)
+		rooms = meeting.breakoutRooms()
+	}
+	message := map[string]interface{}{
+		"action":       "reset",
+		"participants": names,
+		"waiting":      waiting,
+		"rooms":        rooms,
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling reset: %v", err)
+	}
+	return data
+}
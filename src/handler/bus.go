@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Bus backends, see Config
+const (
+	busLocal  = "local"
+	busSQLite = "sqlite"
+)
+
+// Bus carries what one instance has to tell the others, so that several instances can serve
+// the same accounts behind a load balancer
+type Bus interface {
+	// Publish delivers an update to the viewers of a meeting on this instance. It is called
+	// within Store.Update once the change was kept and must not block.
+	Publish(accountID, meetingUUID string, u update)
+	// Forward writes an update for the other instances in the transaction that saves the
+	// meeting, so they receive the updates in order and only if the change was kept
+	Forward(tx *sql.Tx, accountID, meetingUUID string, u update) error
+	// AccountChanged makes all instances drop the cached credentials and policy of an account
+	// and, if disconnect is set, close its viewer connections
+	AccountChanged(accountID string, disconnect bool)
+}
+
+// bus connects this instance to the others, see Config
+var bus Bus = localBus{}
+
+// newBus creates the bus selected in the configuration
+func newBus(cfg *Config, db *sql.DB) (Bus, error) {
+	switch cfg.Bus {
+	case busLocal:
+		return localBus{}, nil
+	case busSQLite:
+		return newSQLiteBus(db, cfg.InstanceID, time.Duration(cfg.BusPollInterval))
+	}
+	return nil, fmt.Errorf("unknown bus %q", cfg.Bus)
+}
+
+// localBus serves a single instance
+type localBus struct{}
+
+// Publish implements Bus
+func (localBus) Publish(accountID, meetingUUID string, u update) {
+	publish(accountID, meetingUUID, u)
+}
+
+// Forward implements Bus, there are no other instances
+func (localBus) Forward(tx *sql.Tx, accountID, meetingUUID string, u update) error {
+	return nil
+}
+
+// AccountChanged implements Bus
+func (localBus) AccountChanged(accountID string, disconnect bool) {
+	accountChanged(accountID, disconnect)
+}
+
+// accountChanged applies a change of an account to this instance
+func accountChanged(accountID string, disconnect bool) {
+	forgetViewerPasswords(accountID)
+	forgetRetention(accountID)
+	if disconnect {
+		closeConnections(accountID)
+	}
+}
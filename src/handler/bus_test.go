@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// remoteBus only passes messages to the other instances. The viewers of both test instances share
+// the hub of the process, so whatever they receive must have come through the database.
+type remoteBus struct {
+	*sqliteBus
+}
+
+func (b remoteBus) Publish(accountID, meetingUUID string, u update) {}
+
+func (b remoteBus) AccountChanged(accountID string, disconnect bool) {
+	b.announce(accountID, disconnect)
+}
+
+// testInstance is the store and bus of one server instance
+type testInstance struct {
+	store *sqliteStore
+	bus   *sqliteBus
+}
+
+// openInstance connects an instance to a database file shared with other instances. Its bus does
+// not poll, the test reads the messages.
+func openInstance(t *testing.T, path, name string) testInstance {
+	t.Helper()
+	db, err := InitDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("PRAGMA journal_mode = WAL"); err != nil {
+		t.Fatal(err)
+	}
+	store, err := newSQLiteStore(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testInstance{store: store, bus: &sqliteBus{db: db, instance: name}}
+}
+
+func TestSharedDatabaseInstances(t *testing.T) {
+	if err := setServerKey(bytes.Repeat([]byte{7}, serverKeySize)); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "shared.db")
+	a := openInstance(t, path, "a")
+	b := openInstance(t, path, "b")
+	resetState(a.bus.db)
+	addTestAccount(t, a.bus.db, "acc", "viewerpassword123", defaultRetention)
+
+	// Instance A writes its updates, instance B reads them when the test polls
+	previous := bus
+	bus = remoteBus{a.bus}
+	t.Cleanup(func() { bus = previous })
+	var lastID int64
+	receive := func() {
+		t.Helper()
+		var err error
+		if lastID, err = b.bus.receive(lastID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v := newViewer("sse", "m1", protocolV2)
+	subscribe(b.store, "acc", "", v)
+
+	h := &handlers{store: a.store}
+	ts := time.Now().UnixMilli()
+	for _, payload := range []ZoomWebhookPayload{
+		webhookPayload("acc", "meeting.participant_joined", "m1", "u1", "Alice", ts),
+		webhookPayload("acc", "meeting.participant_joined", "m1", "u2", "Bob", ts+1),
+		webhookPayload("acc", "meeting.participant_left", "m1", "u1", "Alice", ts+2),
+	} {
+		if code := sendWebhook(t, h, payload); code != http.StatusOK {
+			t.Fatalf("webhook %s: got %d", payload.Event, code)
+		}
+	}
+
+	// The viewer on instance B receives the updates of instance A in order
+	want := []string{"join", "join", "leave"}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(v.queue) < len(want) && time.Now().Before(deadline) {
+		receive()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(v.queue) != len(want) {
+		t.Fatalf("viewer on instance B received %d updates, want %d", len(v.queue), len(want))
+	}
+	for i, kind := range want {
+		u := <-v.queue
+		var message struct {
+			Type string `json:"type"`
+			Seq  uint64 `json:"seq"`
+		}
+		if err := json.Unmarshal(u.v2, &message); err != nil {
+			t.Fatal(err)
+		}
+		if u.seq != uint64(i+1) || message.Seq != u.seq || message.Type != kind {
+			t.Errorf("update %d: got %s with seq %d (message seq %d), want %s with seq %d", i, message.Type, u.seq, message.Seq, kind, i+1)
+		}
+	}
+
+	// A viewer connecting to instance B later starts from the state saved by instance A
+	late := newViewer("ws", "m1", protocolV2)
+	subscribe(b.store, "acc", "", late)
+	var snapshot struct {
+		Seq          uint64        `json:"seq"`
+		Participants []viewerEntry `json:"participants"`
+	}
+	if err := json.Unmarshal(late.backlog[len(late.backlog)-1].v2, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Seq != 3 || len(snapshot.Participants) != 1 || snapshot.Participants[0].Name != "Bob" {
+		t.Errorf("snapshot on instance B: %+v", snapshot)
+	}
+
+	// Changing the credentials on instance A disconnects the viewers on instance B
+	bus.AccountChanged("acc", true)
+	receive()
+	for _, viewer := range []*viewer{v, late} {
+		if !isDisconnected(viewer) || isSubscribed("acc", viewer) {
+			t.Errorf("%s viewer on instance B: disconnected %v, subscribed %v", viewer.transport, isDisconnected(viewer), isSubscribed("acc", viewer))
+		}
+	}
+}
+
+func TestBusWriteFailureFailsUpdate(t *testing.T) {
+	db := newTestDB(t)
+	addTestAccount(t, db, "acc", "viewerpassword123", defaultRetention)
+	store, err := newSQLiteStore(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := bus
+	bus = &sqliteBus{db: db, instance: "a"}
+	t.Cleanup(func() { bus = previous })
+	h := &handlers{store: store}
+	v := newViewer("sse", "m1", protocolV2)
+	subscribe(store, "acc", "", v)
+
+	// An update the other instances would miss is not applied at all, Zoom retries it
+	if _, err := db.Exec("CREATE TRIGGER fail_bus BEFORE INSERT ON bus_messages BEGIN SELECT RAISE(ABORT, 'disk full'); END"); err != nil {
+		t.Fatal(err)
+	}
+	payload := webhookPayload("acc", "meeting.participant_joined", "m1", "u1", "Alice", time.Now().UnixMilli())
+	if code := sendWebhook(t, h, payload); code != http.StatusInternalServerError {
+		t.Fatalf("failing bus: got %d", code)
+	}
+	rows := func(table string) int {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	if count := rows("meetings"); count != 0 || len(v.queue) != 0 {
+		t.Fatalf("failed update: %d meetings saved, %d updates sent", count, len(v.queue))
+	}
+
+	if _, err := db.Exec("DROP TRIGGER fail_bus"); err != nil {
+		t.Fatal(err)
+	}
+	if code := sendWebhook(t, h, payload); code != http.StatusOK {
+		t.Fatalf("retried webhook: got %d", code)
+	}
+	if rows("meetings") != 1 || rows("bus_messages") != 1 || len(v.queue) != 1 {
+		t.Errorf("retried update: %d meetings, %d bus messages, %d updates sent", rows("meetings"), rows("bus_messages"), len(v.queue))
+	}
+}
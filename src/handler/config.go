@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultConfigFile is read if CONFIG_FILE is not set. Unlike an explicitly named file it may be missing.
+const defaultConfigFile = "./config.json"
+
+// Config holds the server settings. They are read from a JSON file and can be overridden by
+// environment variables, see the README for their names.
+type Config struct {
+	ListenHost       string   `json:"listen_host"`
+	Port             string   `json:"port"`
+	UnixSocket       string   `json:"unix_socket"` // Listen on this socket instead of ListenHost and Port
+	PublicBaseURL    string   `json:"public_base_url"`
+	AllowedOrigins   []string `json:"allowed_origins"` // Origins that may open a WebSocket, the origin of PublicBaseURL is always allowed
+	DatabasePath     string   `json:"database_path"`
+	ServerKeyFile    string   `json:"server_key_file"`
+	MeetingRetention Duration `json:"meeting_retention"` // Meetings without updates for this long are removed, whatever the account's policy
+	CleanupInterval  Duration `json:"cleanup_interval"`
+	TrustedProxies   []string `json:"trusted_proxies"`
+	WebhookMaxAge    Duration `json:"webhook_max_age"`
+	WebhookClockSkew Duration `json:"webhook_clock_skew"`
+	SnapshotFile     string   `json:"snapshot_file"` // Keep live meetings across restarts in this encrypted file, empty to disable
+	SnapshotInterval Duration `json:"snapshot_interval"`
+	Store            string   `json:"store"` // Where meetings are kept, "memory" or "sqlite"
+	Bus              string   `json:"bus"`   // How instances sharing the database exchange updates, "local" for a single instance or "sqlite"
+	BusPollInterval  Duration `json:"bus_poll_interval"`
+	InstanceID       string   `json:"instance_id"`   // Name of this instance on the bus, random if empty
+	MetricsToken     string   `json:"metrics_token"` // Bearer token unlocking the metrics per account, empty to only report totals
+}
+
+// Duration is a time.Duration written as a string such as "6h" in the configuration file
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"6h\": %v", err)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// defaultConfig returns the settings used when neither the file nor the environment sets them
+func defaultConfig() *Config {
+	return &Config{
+		ListenHost:       "localhost",
+		Port:             "8080",
+		AllowedOrigins:   []string{"http://localhost:8080", "https://zoom.8bj.de"},
+		DatabasePath:     "./zoom_accounts.db",
+		ServerKeyFile:    "./zoom_server.key",
+		MeetingRetention: Duration(6 * time.Hour),
+		CleanupInterval:  Duration(time.Minute),
+		WebhookMaxAge:    Duration(5 * time.Minute),
+		WebhookClockSkew: Duration(30 * time.Second),
+		SnapshotInterval: Duration(time.Minute),
+		Store:            storeMemory,
+		Bus:              busLocal,
+		BusPollInterval:  Duration(250 * time.Millisecond),
+	}
+}
+
+// LoadConfig reads the configuration file named by CONFIG_FILE (default ./config.json), applies
+// the environment overrides and validates the result
+func LoadConfig() (*Config, error) {
+	cfg := defaultConfig()
+
+	path := os.Getenv("CONFIG_FILE")
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+	content, err := os.ReadFile(path)
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %v", path, err)
+		}
+	} else if explicit || !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read configuration file: %v", err)
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides settings with the environment variables that are set
+func (c *Config) applyEnv() error {
+	texts := map[string]*string{
+		"LISTEN_HOST":     &c.ListenHost,
+		"PORT":            &c.Port,
+		"UNIX":            &c.UnixSocket,
+		"PUBLIC_BASE_URL": &c.PublicBaseURL,
+		"DATABASE_PATH":   &c.DatabasePath,
+		"SERVER_KEY_FILE": &c.ServerKeyFile,
+		"SNAPSHOT_FILE":   &c.SnapshotFile,
+		"STORE":           &c.Store,
+		"BUS":             &c.Bus,
+		"INSTANCE_ID":     &c.InstanceID,
+		"METRICS_TOKEN":   &c.MetricsToken,
+	}
+	for name, field := range texts {
+		if value, set := os.LookupEnv(name); set {
+			*field = value
+		}
+	}
+
+	lists := map[string]*[]string{
+		"ALLOWED_ORIGINS": &c.AllowedOrigins,
+		"TRUSTED_PROXIES": &c.TrustedProxies,
+	}
+	for name, field := range lists {
+		if value, set := os.LookupEnv(name); set {
+			*field = splitList(value)
+		}
+	}
+
+	durations := map[string]*Duration{
+		"MEETING_RETENTION":  &c.MeetingRetention,
+		"CLEANUP_INTERVAL":   &c.CleanupInterval,
+		"WEBHOOK_MAX_AGE":    &c.WebhookMaxAge,
+		"WEBHOOK_CLOCK_SKEW": &c.WebhookClockSkew,
+		"SNAPSHOT_INTERVAL":  &c.SnapshotInterval,
+		"BUS_POLL_INTERVAL":  &c.BusPollInterval,
+	}
+	for name, field := range durations {
+		if value, set := os.LookupEnv(name); set {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %v", name, value, err)
+			}
+			*field = Duration(parsed)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// validate reports all invalid settings at once
+func (c *Config) validate() error {
+	var errs []error
+	if c.UnixSocket == "" {
+		if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("port %q must be a number between 1 and 65535", c.Port))
+		}
+	}
+	if c.PublicBaseURL != "" {
+		if _, err := parseOrigin(c.PublicBaseURL, true); err != nil {
+			errs = append(errs, fmt.Errorf("public base URL: %v", err))
+		}
+	}
+	for _, origin := range c.AllowedOrigins {
+		if _, err := parseOrigin(origin, false); err != nil {
+			errs = append(errs, fmt.Errorf("allowed origin: %v", err))
+		}
+	}
+	if c.DatabasePath == "" {
+		errs = append(errs, errors.New("database path must not be empty"))
+	}
+	if c.ServerKeyFile == "" {
+		errs = append(errs, errors.New("server key file must not be empty"))
+	}
+	if c.MeetingRetention <= 0 {
+		errs = append(errs, errors.New("meeting retention must be positive"))
+	}
+	if c.CleanupInterval <= 0 || c.CleanupInterval > c.MeetingRetention {
+		errs = append(errs, errors.New("cleanup interval must be positive and not exceed the meeting retention"))
+	}
+	for _, entry := range c.TrustedProxies {
+		if _, err := parseTrustedProxy(entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.WebhookMaxAge <= 0 {
+		errs = append(errs, errors.New("webhook max age must be positive"))
+	}
+	if c.WebhookClockSkew < 0 {
+		errs = append(errs, errors.New("webhook clock skew must not be negative"))
+	}
+	if c.SnapshotFile != "" && c.SnapshotInterval <= 0 {
+		errs = append(errs, errors.New("snapshot interval must be positive"))
+	}
+	if c.Store != storeMemory && c.Store != storeSQLite {
+		errs = append(errs, fmt.Errorf("store %q must be %q or %q", c.Store, storeMemory, storeSQLite))
+	}
+	switch c.Bus {
+	case busLocal:
+	case busSQLite:
+		if c.Store != storeSQLite {
+			errs = append(errs, errors.New("the sqlite bus needs the sqlite store, so that instances share the meetings"))
+		}
+		if c.BusPollInterval <= 0 {
+			errs = append(errs, errors.New("bus poll interval must be positive"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("bus %q must be %q or %q", c.Bus, busLocal, busSQLite))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// applyConfig makes the settings effective for the handlers. The configuration must be valid.
+func applyConfig(c *Config) {
+	allowedOrigins = make(map[string]bool)
+	for _, origin := range c.AllowedOrigins {
+		normalized, _ := parseOrigin(origin, false)
+		allowedOrigins[normalized] = true
+	}
+	publicHTTPS = false
+	if c.PublicBaseURL != "" {
+		normalized, _ := parseOrigin(c.PublicBaseURL, true)
+		allowedOrigins[normalized] = true
+		publicHTTPS = strings.HasPrefix(normalized, "https://")
+	}
+
+	trustedProxies = nil
+	for _, entry := range c.TrustedProxies {
+		network, _ := parseTrustedProxy(entry)
+		trustedProxies = append(trustedProxies, network)
+	}
+	webhookMaxAge = time.Duration(c.WebhookMaxAge)
+	webhookClockSkew = time.Duration(c.WebhookClockSkew)
+	meetingRetention = time.Duration(c.MeetingRetention)
+	cleanupInterval = time.Duration(c.CleanupInterval)
+	snapshotFile = c.SnapshotFile
+	snapshotInterval = time.Duration(c.SnapshotInterval)
+	metricsToken = c.MetricsToken
+}
+
+// ListenAddr returns the TCP address to listen on
+func (c *Config) ListenAddr() string {
+	return net.JoinHostPort(c.ListenHost, c.Port)
+}
+
+// parseOrigin normalizes an http or https URL to its origin. A base URL may have a path, an origin may not.
+func parseOrigin(value string, allowPath bool) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a valid URL: %v", value, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q must start with http:// or https:// and name a host", value)
+	}
+	if (!allowPath && strings.Trim(u.Path, "/") != "") || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%q must not contain a path, query or fragment", value)
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), nil
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	serverKeySize          = 32
+	viewerPasswordHashIter = 600000
+	encryptedSecretPrefix  = "v1:"
+)
+
+var (
+	// secretKey encrypts secret tokens at rest, lookupKey derives the lookup identifier of viewer passwords,
+	// snapshotKey encrypts the snapshot of live meetings and storeKey the meetings of the SQLite store
+	secretKey   []byte
+	lookupKey   []byte
+	snapshotKey []byte
+	storeKey    []byte
+
+	errServerKeyMissing = errors.New("server key not loaded")
+)
+
+// LoadServerKey loads the server key from the SERVER_KEY environment variable (hex encoded) or from
+// the given key file. A missing key file is created with a random key.
+func LoadServerKey(keyFile string) error {
+	var key []byte
+	if value := os.Getenv("SERVER_KEY"); value != "" {
+		decoded, err := hex.DecodeString(strings.TrimSpace(value))
+		if err != nil || len(decoded) != serverKeySize {
+			return fmt.Errorf("SERVER_KEY must be %d hex encoded bytes", serverKeySize)
+		}
+		key = decoded
+	} else {
+		var err error
+		if key, err = readOrCreateKeyFile(keyFile); err != nil {
+			return err
+		}
+	}
+	return setServerKey(key)
+}
+
+// readOrCreateKeyFile reads a hex encoded key from path, generating and storing a new key if the file does not exist
+func readOrCreateKeyFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, serverKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate server key: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write server key file: %v", err)
+		}
+		log.Printf("Generated new server key in %s", path)
+		return key, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read server key file: %v", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != serverKeySize {
+		return nil, fmt.Errorf("server key file %s must contain %d hex encoded bytes", path, serverKeySize)
+	}
+	return key, nil
+}
+
+// setServerKey derives the purpose-specific keys from the server key
+func setServerKey(key []byte) error {
+	var err error
+	if secretKey, err = hkdf.Key(sha256.New, key, nil, "zoomParticipants secret token", serverKeySize); err != nil {
+		return err
+	}
+	if lookupKey, err = hkdf.Key(sha256.New, key, nil, "zoomParticipants viewer lookup", serverKeySize); err != nil {
+		return err
+	}
+	if sessionKey, err = hkdf.Key(sha256.New, key, nil, "zoomParticipants session", serverKeySize); err != nil {
+		return err
+	}
+	if snapshotKey, err = hkdf.Key(sha256.New, key, nil, "zoomParticipants snapshot", serverKeySize); err != nil {
+		return err
+	}
+	if storeKey, err = hkdf.Key(sha256.New, key, nil, "zoomParticipants meeting store", serverKeySize); err != nil {
+		return err
+	}
+	return nil
+}
+
+// viewerLookup returns the identifier under which a viewer password is stored and cached.
+// It is deterministic so that accounts can be found by password without storing the password.
+func viewerLookup(viewerPassword string) string {
+	h := hmac.New(sha256.New, lookupKey)
+	h.Write([]byte(viewerPassword))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashViewerPassword hashes a viewer password with PBKDF2 and a random salt
+func hashViewerPassword(viewerPassword string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash, err := pbkdf2.Key(sha256.New, viewerPassword, salt, viewerPasswordHashIter, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", viewerPasswordHashIter,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// verifyViewerPassword checks a viewer password against a hash created by hashViewerPassword
+func verifyViewerPassword(viewerPassword, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	hash, err := pbkdf2.Key(sha256.New, viewerPassword, salt, iter, len(expected))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hash, expected) == 1
+}
+
+// encryptSecret encrypts a secret token with AES-GCM, binding it to the account ID
+func encryptSecret(accountID, secretToken string) (string, error) {
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secretToken), []byte(accountID))
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret reverses encryptSecret
+func decryptSecret(accountID, encrypted string) (string, error) {
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(encrypted, encryptedSecretPrefix) {
+		return "", errors.New("unknown secret token format")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, encryptedSecretPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed secret token")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(accountID))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// secretCipher returns the AEAD used for secret tokens
+func secretCipher() (cipher.AEAD, error) {
+	if secretKey == nil {
+		return nil, errServerKeyMissing
+	}
+	block, err := aes.NewCipher(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
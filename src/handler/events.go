@@ -0,0 +1,38 @@
+package handler
+
+// Subjects group the events that must be applied in order for one participant
+const (
+	presenceSubject = "presence:"
+	roomSubject     = "room:"
+	waitingSubject  = "waiting:"
+)
+
+// stale reports whether an event with the given timestamp is older than the last event applied to the
+// subject or than the end of the meeting. Events without a timestamp are never stale.
+func (m *MeetingData) stale(subject string, ts int64) bool {
+	if ts == 0 {
+		return false
+	}
+	if m.EndedTS != 0 && ts < m.EndedTS {
+		return true
+	}
+	last, exists := m.Applied[subject]
+	return exists && ts < last.TS
+}
+
+// accept reports whether an event should be applied to the subject and records it if so.
+// Zoom retries webhooks and may deliver them out of order, so exact duplicates and events older
+// than the last applied one are rejected.
+func (m *MeetingData) accept(subject, event string, ts int64) bool {
+	if ts == 0 {
+		return true
+	}
+	if m.stale(subject, ts) {
+		return false
+	}
+	if last, exists := m.Applied[subject]; exists && last.TS == ts && last.Event == event {
+		return false
+	}
+	m.Applied[subject] = EventStamp{Event: event, TS: ts}
+	return true
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"slices"
+	"testing"
+)
+
+// testEvent is one webhook delivery of a reordering test
+type testEvent struct {
+	name   string // Webhook event without the "meeting." prefix
+	userID string
+	ts     int64
+	room   string // Breakout room UUID
+	parent string // Main meeting user ID of a breakout room participant
+}
+
+// payload converts the event to a webhook of participant Alice or meeting m1
+func (e testEvent) payload() ZoomWebhookPayload {
+	payload := webhookPayload("acc", "meeting."+e.name, "m1", e.userID, "Alice", e.ts)
+	payload.Payload.Object.BreakoutRoomUUID = e.room
+	payload.Payload.Object.Participant.ParentUserID = e.parent
+	return payload
+}
+
+func TestEventReordering(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   []testEvent
+		present  []string
+		waiting  []string
+		rooms    int
+		sessions int // Attendance sessions of u1
+	}{
+		{
+			name:     "leave before stale join",
+			events:   []testEvent{{name: "participant_left", userID: "u1", ts: 200}, {name: "participant_joined", userID: "u1", ts: 100}},
+			present:  []string{},
+			sessions: 0,
+		},
+		{
+			name:     "join and leave in order",
+			events:   []testEvent{{name: "participant_joined", userID: "u1", ts: 100}, {name: "participant_left", userID: "u1", ts: 200}},
+			present:  []string{},
+			sessions: 1,
+		},
+		{
+			name:     "exact duplicate",
+			events:   []testEvent{{name: "participant_joined", userID: "u1", ts: 100}, {name: "participant_joined", userID: "u1", ts: 100}},
+			present:  []string{"Alice"},
+			sessions: 1,
+		},
+		{
+			name:     "rejoin after leave",
+			events:   []testEvent{{name: "participant_joined", userID: "u1", ts: 100}, {name: "participant_left", userID: "u1", ts: 200}, {name: "participant_joined", userID: "u1", ts: 300}},
+			present:  []string{"Alice"},
+			sessions: 2,
+		},
+		{
+			name:     "join after meeting ended",
+			events:   []testEvent{{name: "participant_joined", userID: "u1", ts: 100}, {name: "ended", ts: 300}, {name: "participant_joined", userID: "u1", ts: 200}},
+			present:  []string{},
+			sessions: 1,
+		},
+		{
+			name: "breakout join after leave",
+			events: []testEvent{
+				{name: "participant_joined", userID: "u1", ts: 100},
+				{name: "participant_left", userID: "u1", ts: 300},
+				{name: "participant_joined_breakout_room", userID: "b1", parent: "u1", room: "r1", ts: 200},
+			},
+			present:  []string{},
+			rooms:    0,
+			sessions: 1,
+		},
+		{
+			name: "breakout join before leave",
+			events: []testEvent{
+				{name: "participant_joined", userID: "u1", ts: 100},
+				{name: "participant_joined_breakout_room", userID: "b1", parent: "u1", room: "r1", ts: 200},
+			},
+			present:  []string{"Alice"},
+			rooms:    1,
+			sessions: 1,
+		},
+		{
+			name:    "admitted before waiting room join",
+			events:  []testEvent{{name: "participant_admitted", userID: "u1", ts: 200}, {name: "participant_joined_waiting_room", userID: "u1", ts: 100}},
+			present: []string{},
+			waiting: []string{},
+		},
+		{
+			name:    "left waiting room before join",
+			events:  []testEvent{{name: "participant_left_waiting_room", userID: "u1", ts: 200}, {name: "participant_joined_waiting_room", userID: "u1", ts: 100}},
+			present: []string{},
+			waiting: []string{},
+		},
+		{
+			name:    "waiting room in order",
+			events:  []testEvent{{name: "participant_joined_waiting_room", userID: "u1", ts: 100}},
+			present: []string{},
+			waiting: []string{"Alice"},
+		},
+		{
+			name:    "back to waiting room after admission",
+			events:  []testEvent{{name: "participant_joined_waiting_room", userID: "u1", ts: 100}, {name: "participant_admitted", userID: "u1", ts: 200}, {name: "participant_joined_waiting_room", userID: "u1", ts: 300}},
+			present: []string{},
+			waiting: []string{"Alice"},
+		},
+	}
+
+	appState.PasswordMutex.Lock()
+	appState.Retention["acc"] = defaultRetention
+	appState.PasswordMutex.Unlock()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meetings := make(map[string]*MeetingData)
+			for _, e := range tt.events {
+				payload := e.payload()
+				eventHandlers[payload.Event](meetings, payload, "acc")
+			}
+
+			meeting := meetings["m1"]
+			if meeting == nil {
+				t.Fatal("meeting was not created")
+			}
+			if present := meeting.presentNames(); !slices.Equal(present, tt.present) {
+				t.Errorf("present: got %v, want %v", present, tt.present)
+			}
+			if tt.waiting != nil {
+				if waiting := sortedNames(meeting.Waiting); !slices.Equal(waiting, tt.waiting) {
+					t.Errorf("waiting: got %v, want %v", waiting, tt.waiting)
+				}
+			}
+			if len(meeting.Rooms) != tt.rooms {
+				t.Errorf("rooms: got %v, want %d assignments", meeting.Rooms, tt.rooms)
+			}
+			sessions := 0
+			if participant := meeting.Participants["u1"]; participant != nil {
+				sessions = len(participant.Sessions)
+			}
+			if sessions != tt.sessions {
+				t.Errorf("sessions of u1: got %d, want %d", sessions, tt.sessions)
+			}
+		})
+	}
+}
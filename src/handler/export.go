@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// attendanceRow is one line of the attendance report
+type attendanceRow struct {
+	Name      string
+	Email     string
+	FirstJoin time.Time
+	LastLeave time.Time
+	Rejoins   int
+	Minutes   int
+}
+
+var attendanceHeader = []string{"Name", "E-Mail", "Erster Beitritt", "Letzter Austritt", "Erneute Beitritte", "Minuten gesamt"}
+
+// attendanceReport builds the attendance rows of a meeting sorted by name.
+// The caller must hold the account mutex.
+func attendanceReport(meeting *MeetingData, now time.Time) []attendanceRow {
+	rows := make([]attendanceRow, 0, len(meeting.Participants))
+	for _, participant := range meeting.Participants {
+		rows = append(rows, attendanceRow{
+			Name:      participant.Name,
+			Email:     participant.Email,
+			FirstJoin: participant.FirstJoin(),
+			LastLeave: participant.LastLeave(),
+			Rejoins:   participant.Rejoins(),
+			Minutes:   int(math.Round(participant.TotalAttended(now).Minutes())),
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Name < rows[j].Name
+	})
+	return rows
+}
+
+// formatReportTime formats a report timestamp in local time, leaving zero times empty
+func formatReportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// exportHandler downloads the attendance report of the selected meeting as CSV or XLSX
+func (h *handlers) exportHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	accountID, authenticated := sessionAccount(r)
+	if !authenticated {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	format := r.FormValue("format")
+	if format != "csv" && format != "xlsx" {
+		http.Error(w, "Unbekanntes Format", http.StatusBadRequest)
+		return
+	}
+
+	// Without a meeting in the request the most recently updated one is exported, but a requested
+	// meeting that is gone must not be replaced by another one
+	requested := r.FormValue("meeting")
+	var rows []attendanceRow
+	var meetingID string
+	found := false
+	h.store.View(accountID, func(meetings map[string]*MeetingData) {
+		meetingUUID, meeting := selectMeeting(meetings, requested)
+		if meeting != nil && (requested == "" || meetingUUID == requested) {
+			rows = attendanceReport(meeting, time.Now())
+			meetingID = meeting.ID
+			found = true
+		}
+	})
+	if !found && requested != "" {
+		http.Error(w, "Meeting nicht gefunden", http.StatusNotFound)
+		return
+	} else if !found {
+		renderError(w, "Keine Meetingdaten vorhanden.")
+		return
+	}
+
+	filename := fmt.Sprintf("anwesenheit-%s-%s.%s", filenamePart(meetingID), time.Now().Format("2006-01-02"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	var err error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = writeAttendanceCSV(w, rows)
+	} else {
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		err = writeAttendanceXLSX(w, rows)
+	}
+	if err != nil {
+		log.Printf("Error writing attendance report: %v", err)
+	}
+}
+
+// filenamePart keeps the characters of a meeting number that are safe in a download filename
+func filenamePart(value string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-' || r == '_' {
+			return r
+		}
+		return -1
+	}, value)
+	if safe == "" {
+		return "meeting"
+	}
+	return safe
+}
+
+// writeAttendanceCSV writes the report with a BOM and semicolons so that spreadsheet
+// applications with German locale settings open it correctly
+func writeAttendanceCSV(w io.Writer, rows []attendanceRow) error {
+	if _, err := w.Write([]byte("\uFEFF")); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	writer.Comma = ';'
+	if err := writer.Write(attendanceHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{
+			csvText(row.Name),
+			csvText(row.Email),
+			formatReportTime(row.FirstJoin),
+			formatReportTime(row.LastLeave),
+			fmt.Sprint(row.Rejoins),
+			fmt.Sprint(row.Minutes),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvText neutralizes text chosen by Zoom users that spreadsheet applications would run as a formula
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// writeAttendanceXLSX writes the report as a single-sheet workbook
+func writeAttendanceXLSX(w io.Writer, rows []attendanceRow) error {
+	cells := make([][]any, 0, len(rows)+1)
+	header := make([]any, len(attendanceHeader))
+	for i, title := range attendanceHeader {
+		header[i] = title
+	}
+	cells = append(cells, header)
+	for _, row := range rows {
+		cells = append(cells, []any{
+			row.Name,
+			row.Email,
+			formatReportTime(row.FirstJoin),
+			formatReportTime(row.LastLeave),
+			row.Rejoins,
+			row.Minutes,
+		})
+	}
+	return writeXLSX(w, "Anwesenheit", cells)
+}
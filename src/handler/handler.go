@@ -12,7 +12,7 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -24,12 +24,17 @@ import (
 
 var (
 	appState = &AppState{
-		Meetings:            make(map[string]map[string]*MeetingData),
-		AccountMutexes:      make(map[string]*sync.RWMutex),
 		PasswordToAccountID: make(map[string]string),
+		Retention:           make(map[string]RetentionPolicy),
 		PasswordMutex:       sync.RWMutex{},
 	}
 	tmpl *template.Template
+	// meetingRetention is how long meetings without updates are kept at most, see Config
+	meetingRetention = 6 * time.Hour
+	// cleanupInterval is how often expired meetings are removed, see Config
+	cleanupInterval = time.Minute
+	// publicHTTPS is set if the public base URL uses HTTPS, so cookies are always marked secure
+	publicHTTPS bool
 )
 
 // Init parses the HTML template for the participant list page
@@ -38,6 +43,10 @@ func Init() {
 		"add": func(a, b int) int {
 			return a + b
 		},
+		"minutes": func(d time.Duration) int {
+			return int(d.Minutes())
+		},
+		"duration": formatRetention,
 	}
 	var err error
 	tmpl, err = template.New("content.gohtml").Funcs(funcMap).ParseFiles("content.gohtml")
@@ -46,45 +55,51 @@ func Init() {
 	}
 }
 
-// InitDB initializes the SQLite database and creates the accounts table if it doesn't exist
+// InitDB opens the SQLite database and migrates it to the current schema.
+// The server key must be loaded before, as existing plaintext credentials are converted on the way.
 func InitDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	// Transactions take the write lock right away, and waiting for other connections and
+	// instances sharing the database does not fail immediately
+	db, err := sql.Open("sqlite3", dbPath+"?_txlock=immediate&_busy_timeout=5000")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
 
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS accounts (
-		account_id TEXT PRIMARY KEY,
-		secret_token TEXT NOT NULL,
-		viewer_password TEXT NOT NULL UNIQUE
-	);`
-	_, err = db.Exec(createTableSQL)
-	if err != nil {
+	if err := migrateDB(db); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("failed to create table: %v", err)
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
 
 	return db, nil
 }
 
-func NewServer(db *sql.DB) *http.Server {
+// NewServer sets up the handlers with the given store and configuration
+func NewServer(db *sql.DB, store Store, cfg *Config) (*http.Server, error) {
 	Init()
+	applyConfig(cfg)
+	var err error
+	if bus, err = newBus(cfg, db); err != nil {
+		return nil, err
+	}
+	if cfg.Store == storeSQLite {
+		seenWebhooks = &replayCache{entries: make(map[string]time.Time), db: db}
+	}
 	r := httprouter.New()
 
-	SetupHandlers(r, db)
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-		log.Printf("defaulting to port %s", port)
+	SetupHandlers(r, db, store)
+	if snapshotFile != "" {
+		if err := restoreSnapshot(store); err != nil {
+			log.Printf("Failed to restore snapshot: %v", err)
+		}
+		go checkpointSnapshots(store)
 	}
 
-	addr := "localhost:" + port
-	return &http.Server{
-		Addr:    addr,
+	server := &http.Server{
+		Addr:    cfg.ListenAddr(),
 		Handler: r,
 	}
+	server.RegisterOnShutdown(disconnectAll)
+	return server, nil
 }
 
 // validateWebhookSignature verifies the incoming webhook signature
@@ -115,75 +130,177 @@ func handleWebhookValidation(w http.ResponseWriter, payload ZoomWebhookPayload,
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleParticipantJoined adds a participant to the meeting data
-func handleParticipantJoined(payload ZoomWebhookPayload, accountID string) {
+// meetingFor returns the meeting addressed by the payload, creating it if necessary
+func meetingFor(meetings map[string]*MeetingData, payload ZoomWebhookPayload) *MeetingData {
 	meetingUUID := payload.Payload.Object.UUID
-	participant := payload.Payload.Object.Participant
-	uniqueID := participant.UserID
-	if uniqueID == "" {
-		uniqueID = participant.UserName
+	if _, exists := meetings[meetingUUID]; !exists {
+		meetings[meetingUUID] = &MeetingData{
+			Participants: make(map[string]*Participant),
+			Waiting:      make(map[string]string),
+			Rooms:        make(map[string]string),
+			Applied:      make(map[string]EventStamp),
+			ID:           payload.Payload.Object.ID,
+			Topic:        payload.Payload.Object.Topic,
+			LastUpdated:  time.Now(),
+			RaffleSeed:   newRaffleSeed(),
+		}
 	}
-	displayName := participant.UserName
-	if displayName == "" {
-		displayName = "Anonymous"
+	return meetings[meetingUUID]
+}
+
+// handleParticipantJoined starts a new attendance session for a participant
+func handleParticipantJoined(meetings map[string]*MeetingData, payload ZoomWebhookPayload, accountID string) {
+	meeting := meetingFor(meetings, payload)
+	uniqueID := meeting.keyFor(payload)
+	if !meeting.accept(presenceSubject+uniqueID, payload.Event, payload.EventTS) {
+		return
 	}
+	participant := meeting.participantFor(payload)
+	if participant.join(eventTime(payload.Payload.Object.Participant.JoinTime)) {
+		broadcastJoined(accountID, payload.Payload.Object.UUID, meeting, participantID(uniqueID), participant.Name)
+	}
+	meeting.LastUpdated = time.Now()
+}
 
-	accountMutex := appState.AccountMutexes[accountID]
-	accountMutex.Lock()
-	defer accountMutex.Unlock()
+// handleParticipantLeft ends the attendance session of a participant
+func handleParticipantLeft(meetings map[string]*MeetingData, payload ZoomWebhookPayload, accountID string) {
+	leaveReason := payload.Payload.Object.Participant.LeaveReason
 
-	if _, exists := appState.Meetings[accountID][meetingUUID]; !exists {
-		appState.Meetings[accountID][meetingUUID] = &MeetingData{
-			Participants: make(map[string]string),
-			Topic:        payload.Payload.Object.Topic,
-			LastUpdated:  time.Now(),
-		}
+	// Zoom reports participants moving into a breakout room as leaving the main session
+	if strings.Contains(strings.ToLower(leaveReason), "breakout") {
+		return
 	}
 
-	meeting := appState.Meetings[accountID][meetingUUID]
-	meeting.Participants[uniqueID] = displayName
+	// The meeting is created even for unknown participants, so that a delayed join cannot revive them
+	meeting := meetingFor(meetings, payload)
+	uniqueID := meeting.keyFor(payload)
+	if !meeting.accept(presenceSubject+uniqueID, payload.Event, payload.EventTS) {
+		return
+	}
+	participant, known := meeting.Participants[uniqueID]
+	if !known || !participant.leave(eventTime(payload.Payload.Object.Participant.LeaveTime)) {
+		return
+	}
+	meeting.LastUpdated = time.Now()
+	broadcastLeft(accountID, payload.Payload.Object.UUID, meeting, participantID(uniqueID), participant.Name)
+	if _, inRoom := meeting.Rooms[uniqueID]; inRoom {
+		delete(meeting.Rooms, uniqueID)
+		broadcastRooms(accountID, payload.Payload.Object.UUID, meeting)
+	}
+}
+
+// handleParticipantJoinedBreakoutRoom records the breakout room a participant moved into
+func handleParticipantJoinedBreakoutRoom(meetings map[string]*MeetingData, payload ZoomWebhookPayload, accountID string) {
+	roomUUID := payload.Payload.Object.BreakoutRoomUUID
+
+	meeting := meetingFor(meetings, payload)
+	uniqueID := meeting.keyFor(payload)
+	// A participant who left the meeting after this event must not reappear in a room
+	if meeting.stale(presenceSubject+uniqueID, payload.EventTS) || !meeting.accept(roomSubject+uniqueID, payload.Event, payload.EventTS) {
+		return
+	}
+	meeting.accept(presenceSubject+uniqueID, payload.Event, payload.EventTS)
+	participant := meeting.participantFor(payload)
+	if participant.join(eventTime(payload.Payload.Object.Participant.JoinTime)) {
+		broadcastJoined(accountID, payload.Payload.Object.UUID, meeting, participantID(uniqueID), participant.Name)
+	}
+	if !slices.Contains(meeting.RoomOrder, roomUUID) {
+		meeting.RoomOrder = append(meeting.RoomOrder, roomUUID)
+	}
+	meeting.Rooms[uniqueID] = roomUUID
 	meeting.LastUpdated = time.Now()
 
-	broadcastJoined(accountID, displayName)
+	broadcastRooms(accountID, payload.Payload.Object.UUID, meeting)
 }
 
-// handleParticipantLeft removes a participant from the meeting data
-func handleParticipantLeft(payload ZoomWebhookPayload, accountID string) {
+// handleParticipantLeftBreakoutRoom moves a participant back to the main session. Events for a
+// room the participant has already left are ignored, so moving between rooms keeps the new room.
+func handleParticipantLeftBreakoutRoom(meetings map[string]*MeetingData, payload ZoomWebhookPayload, accountID string) {
 	meetingUUID := payload.Payload.Object.UUID
-	participant := payload.Payload.Object.Participant
-	uniqueID := participant.UserID
-	if uniqueID == "" {
-		uniqueID = participant.UserName
+
+	if meeting, exists := meetings[meetingUUID]; exists {
+		uniqueID := meeting.keyFor(payload)
+		if !meeting.accept(roomSubject+uniqueID, payload.Event, payload.EventTS) {
+			return
+		}
+		if meeting.Rooms[uniqueID] == payload.Payload.Object.BreakoutRoomUUID {
+			delete(meeting.Rooms, uniqueID)
+			meeting.LastUpdated = time.Now()
+			broadcastRooms(accountID, payload.Payload.Object.UUID, meeting)
+		}
+	}
+}
+
+// handleParticipantJoinedWaitingRoom adds a participant to the waiting list of the meeting
+func handleParticipantJoinedWaitingRoom(meetings map[string]*MeetingData, payload ZoomWebhookPayload, accountID string) {
+	uniqueID := participantKey(payload)
+	displayName := participantName(payload)
+
+	meeting := meetingFor(meetings, payload)
+	if !meeting.accept(waitingSubject+uniqueID, payload.Event, payload.EventTS) {
+		return
 	}
+	meeting.Waiting[uniqueID] = displayName
+	meeting.LastUpdated = time.Now()
 
-	accountMutex := appState.AccountMutexes[accountID]
-	accountMutex.Lock()
-	defer accountMutex.Unlock()
+	broadcastWaitingJoined(accountID, payload.Payload.Object.UUID, meeting, participantID(uniqueID), displayName)
+}
+
+// handleParticipantLeftWaitingRoom removes a participant from the waiting list, either because
+// the host admitted them or because they left the waiting room
+func handleParticipantLeftWaitingRoom(meetings map[string]*MeetingData, payload ZoomWebhookPayload, accountID string) {
+	uniqueID := participantKey(payload)
 
-	if meeting, exists := appState.Meetings[accountID][meetingUUID]; exists {
-		delete(meeting.Participants, uniqueID)
+	meeting := meetingFor(meetings, payload)
+	if !meeting.accept(waitingSubject+uniqueID, payload.Event, payload.EventTS) {
+		return
+	}
+	if displayName, waiting := meeting.Waiting[uniqueID]; waiting {
+		delete(meeting.Waiting, uniqueID)
 		meeting.LastUpdated = time.Now()
-		broadcastLeft(accountID, participant.UserName)
+		broadcastWaitingLeft(accountID, payload.Payload.Object.UUID, meeting, participantID(uniqueID), displayName)
 	}
 }
 
-// handleMeetingEnded clears all participants when the meeting ends
-func handleMeetingEnded(payload ZoomWebhookPayload, accountID string) {
+// handleMeetingEnded closes all attendance sessions when the meeting ends
+func handleMeetingEnded(meetings map[string]*MeetingData, payload ZoomWebhookPayload, accountID string) {
 	meetingUUID := payload.Payload.Object.UUID
+	policy := retentionFor(accountID)
 
-	accountMutex := appState.AccountMutexes[accountID]
-	accountMutex.Lock()
-	defer accountMutex.Unlock()
-
-	if meeting, exists := appState.Meetings[accountID][meetingUUID]; exists {
-		meeting.Participants = make(map[string]string)
+	if meeting, exists := meetings[meetingUUID]; exists {
+		endedAt := time.Now()
+		for _, participant := range meeting.Participants {
+			participant.leave(endedAt)
+		}
+		meeting.Ended = true
+		meeting.EndedAt = endedAt
+		meeting.EndedTS = payload.EventTS
+		meeting.Waiting = make(map[string]string)
+		meeting.Rooms = make(map[string]string)
+		meeting.RoomOrder = nil
 		meeting.LastUpdated = time.Now()
-		broadcastParticipants(accountID, meeting.Participants)
+		broadcastMeetingEnded(accountID, payload.Payload.Object.UUID, meeting, meeting.presentNames())
+		if policy.expired(meeting, endedAt) {
+			delete(meetings, meetingUUID)
+			log.Printf("Removed ended meeting: %s for account: %s", meetingUUID, accountID)
+		}
 	}
 }
 
+// eventHandlers apply the webhook events to the meetings of an account
+var eventHandlers = map[string]func(meetings map[string]*MeetingData, payload ZoomWebhookPayload, accountID string){
+	"meeting.participant_joined":               handleParticipantJoined,
+	"meeting.participant_left":                 handleParticipantLeft,
+	"meeting.participant_joined_waiting_room":  handleParticipantJoinedWaitingRoom,
+	"meeting.participant_admitted":             handleParticipantLeftWaitingRoom,
+	"meeting.participant_left_waiting_room":    handleParticipantLeftWaitingRoom,
+	"meeting.participant_joined_breakout_room": handleParticipantJoinedBreakoutRoom,
+	"meeting.participant_left_breakout_room":   handleParticipantLeftBreakoutRoom,
+	"meeting.ended":                            handleMeetingEnded,
+}
+
 // webhookHandler processes incoming Zoom webhook events
-func webhookHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (h *handlers) webhookHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
@@ -198,8 +315,8 @@ func webhookHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 	}
 
 	accountID := payload.Payload.AccountID
-	var secretToken string
-	err = appState.DB.QueryRow("SELECT secret_token FROM accounts WHERE account_id = ?", accountID).Scan(&secretToken)
+	var encryptedToken, status string
+	err = appState.DB.QueryRow("SELECT secret_token_enc, status FROM accounts WHERE account_id = ?", accountID).Scan(&encryptedToken, &status)
 	if errors.Is(err, sql.ErrNoRows) {
 		http.Error(w, "Unknown account", http.StatusUnauthorized)
 		return
@@ -207,6 +324,12 @@ func webhookHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	secretToken, err := decryptSecret(accountID, encryptedToken)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Printf("Failed to decrypt secret token for account %s: %v", accountID, err)
+		return
+	}
 
 	if !validateWebhookSignature(r, body, secretToken) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -214,37 +337,73 @@ func webhookHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 		return
 	}
 
-	// Initialize or get account-specific mutex (using password mutex for initialization)
+	expires, err := checkWebhookTimestamp(r.Header.Get("x-zm-request-timestamp"))
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		log.Printf("Webhook rejected for account %s: %v", accountID, err)
+		return
+	}
+
+	// Load the cached password and retention policy of the account
 	ensureAccountInitialized(accountID)
 
-	// Process event with account-specific lock
-	switch payload.Event {
-	case "endpoint.url_validation":
+	if payload.Event == "endpoint.url_validation" {
 		handleWebhookValidation(w, payload, secretToken)
-	case "meeting.participant_joined":
-		handleParticipantJoined(payload, accountID)
-	case "meeting.participant_left":
-		handleParticipantLeft(payload, accountID)
-	case "meeting.ended":
-		handleMeetingEnded(payload, accountID)
-	default:
+		return
+	}
+	// The registrant chose the secret token, so a signed webhook does not prove that they own the
+	// Zoom account. Events of accounts that were not verified are confirmed but not applied.
+	if status != accountActive {
+		w.WriteHeader(http.StatusOK)
+		log.Printf("Ignoring webhook for unverified account: %s", accountID)
+		return
+	}
+	handle, known := eventHandlers[payload.Event]
+	if !known {
 		w.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
 
+	// Reserve the signature before applying the event, so that concurrent deliveries apply it once
+	signature := r.Header.Get("x-zm-signature")
+	if err := reserveWebhook(signature, expires); errors.Is(err, errWebhookReplayed) {
+		// The event was already applied, confirm the delivery again without applying it twice
+		w.WriteHeader(http.StatusOK)
+		log.Printf("Webhook for account %s delivered again, skipping", accountID)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Printf("Failed to record webhook of account %s: %v", accountID, err)
+		return
+	}
+
+	// Process event with account-specific lock
+	err = h.store.Update(accountID, func(meetings map[string]*MeetingData) {
+		handle(meetings, payload, accountID)
+	})
+	if err != nil {
+		// Zoom retries webhooks that failed, which must not be taken for replays
+		releaseWebhook(signature)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Printf("Failed to store meetings of account %s: %v", accountID, err)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
+// ensureAccountInitialized caches the viewer password lookup and the retention policy of an account
 func ensureAccountInitialized(accountID string) {
 	appState.PasswordMutex.Lock()
 	defer appState.PasswordMutex.Unlock()
-	if _, exists := appState.AccountMutexes[accountID]; !exists {
-		appState.AccountMutexes[accountID] = &sync.RWMutex{}
-		appState.Meetings[accountID] = make(map[string]*MeetingData)
-		var viewerPassword string
-		err := appState.DB.QueryRow("SELECT viewer_password FROM accounts WHERE account_id = ?", accountID).Scan(&viewerPassword)
+	if _, exists := appState.Retention[accountID]; !exists {
+		var lookup string
+		err := appState.DB.QueryRow("SELECT viewer_lookup FROM accounts WHERE account_id = ? AND status = ?", accountID, accountActive).Scan(&lookup)
 		if err == nil {
-			appState.PasswordToAccountID[viewerPassword] = accountID
+			appState.PasswordToAccountID[lookup] = accountID
+		}
+		if policy, err := loadRetention(accountID); err == nil {
+			appState.Retention[accountID] = policy
 		}
 	}
 }
@@ -266,11 +425,30 @@ func addAccountHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 		return
 	}
 
-	// Insert into database with uniqueness check for viewer_password
-	insertSQL := `INSERT INTO accounts (account_id, secret_token, viewer_password) VALUES (?, ?, ?)`
-	_, err := appState.DB.Exec(insertSQL, accountID, secretToken, viewerPassword)
+	encryptedToken, err := encryptSecret(accountID, secretToken)
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		renderError(w, fmt.Sprintf("Fehler beim Hinzufügen des Kontos: %v", err))
+		return
+	}
+	viewerHash, err := hashViewerPassword(viewerPassword)
+	if err != nil {
+		renderError(w, fmt.Sprintf("Fehler beim Hinzufügen des Kontos: %v", err))
+		return
+	}
+
+	// Registrations that were never verified no longer block the account ID
+	if err := expirePendingAccounts(); err != nil {
+		renderError(w, fmt.Sprintf("Fehler beim Hinzufügen des Kontos: %v", err))
+		return
+	}
+
+	// Insert into database with uniqueness check for the viewer password lookup
+	insertSQL := `INSERT INTO accounts (account_id, secret_token_enc, viewer_lookup, viewer_hash, status, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = appState.DB.Exec(insertSQL, accountID, encryptedToken, viewerLookup(viewerPassword), viewerHash, accountPending, time.Now().Unix())
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: accounts.account_id") {
+			renderError(w, "Diese Konto-ID ist bereits registriert.")
+		} else if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 			renderError(w, "Das Viewer-Passwort ist nicht sicher genug.")
 		} else {
 			renderError(w, fmt.Sprintf("Fehler beim Hinzufügen des Kontos: %v", err))
@@ -278,95 +456,232 @@ func addAccountHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 		return
 	}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	renderTemplate(w, pageData{InfoMessage: fmt.Sprintf("Konto angelegt. Bitte bestätigen Sie es innerhalb von %d Stunden mit der Client-ID und dem Client Secret einer Server-to-Server-OAuth-App des Zoom-Kontos.", int(pendingAccountTTL.Hours()))})
 }
 
-// viewParticipantsHandler displays the participant list or password prompt
-func viewParticipantsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	authenticated := false
-	var accountID string
-	var errorMessage string
+// authenticateViewer resolves the account a viewer password belongs to. On failure the returned
+// account ID is empty and the error message explains the problem to the user. Failed attempts
+// slow down further attempts from the same client.
+func authenticateViewer(r *http.Request, viewerPassword string) (accountID string, errorMessage string) {
+	ip := clientIP(r)
+	if !loginLimits.allow(ip, clock()) {
+		blockedLoginAttempts.Add(1)
+		return "", "Zu viele Fehlversuche. Bitte versuchen Sie es später erneut."
+	}
+	accountID, errorMessage = checkViewerPassword(viewerPassword)
+	if accountID == "" {
+		loginLimits.failure(ip, clock())
+	} else {
+		loginLimits.success(ip)
+	}
+	return accountID, errorMessage
+}
+
+// authenticateToken checks the bearer token of an API request, which is a viewer password. Unlike
+// logins, valid tokens do not spend the global limit, so tools polling the API cannot lock viewers
+// out. Only wrong tokens count towards the limits.
+func authenticateToken(r *http.Request, token string) (accountID string, errorMessage string) {
+	ip := clientIP(r)
+	now := clock()
+	// Looking up the cache is cheap, but guessing must still be slowed down for clients that failed before
+	if !loginLimits.admit(ip, now, false) {
+		blockedLoginAttempts.Add(1)
+		return "", "Zu viele Fehlversuche. Bitte versuchen Sie es später erneut."
+	}
+	if accountID := cachedViewerAccount(token); accountID != "" {
+		return accountID, ""
+	}
+	// Checking the password hash is expensive and guarded by the global limit
+	if !loginLimits.admit(ip, now, true) {
+		blockedLoginAttempts.Add(1)
+		return "", "Zu viele Fehlversuche. Bitte versuchen Sie es später erneut."
+	}
+	accountID, errorMessage = checkViewerPassword(token)
+	if accountID == "" {
+		loginLimits.penalize(ip, clock())
+	} else {
+		loginLimits.success(ip)
+	}
+	return accountID, errorMessage
+}
+
+// cachedViewerAccount returns the account of a viewer password that was checked before, if any
+func cachedViewerAccount(viewerPassword string) string {
+	appState.PasswordMutex.RLock()
+	defer appState.PasswordMutex.RUnlock()
+	return appState.PasswordToAccountID[viewerLookup(viewerPassword)]
+}
 
+// checkViewerPassword looks up the account of a viewer password in the cache and the database
+func checkViewerPassword(viewerPassword string) (accountID string, errorMessage string) {
+	if accountID := cachedViewerAccount(viewerPassword); accountID != "" {
+		return accountID, ""
+	}
+	lookup := viewerLookup(viewerPassword)
+
+	// Fallback to database check
+	var viewerHash, status string
+	err := appState.DB.QueryRow("SELECT account_id, viewer_hash, status FROM accounts WHERE viewer_lookup = ?", lookup).Scan(&accountID, &viewerHash, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "Falsches Passwort."
+	} else if err != nil {
+		return "", "Datenbankfehler bei der Authentifizierung."
+	}
+	if !verifyViewerPassword(viewerPassword, viewerHash) {
+		return "", "Falsches Passwort."
+	}
+	if status != accountActive {
+		return "", "Das Konto wurde noch nicht mit den Zugangsdaten einer Zoom-App bestätigt."
+	}
+	appState.PasswordMutex.Lock()
+	appState.PasswordToAccountID[lookup] = accountID
+	appState.PasswordMutex.Unlock()
+	return accountID, ""
+}
+
+// latestMeeting returns the most recently updated of the given meetings
+func latestMeeting(meetings map[string]*MeetingData) (string, *MeetingData) {
+	var latest *MeetingData
+	var latestUUID string
+	for uuid, meeting := range meetings {
+		if latest == nil || meeting.LastUpdated.After(latest.LastUpdated) {
+			latest = meeting
+			latestUUID = uuid
+		}
+	}
+	return latestUUID, latest
+}
+
+// selectMeeting returns the requested meeting, falling back to the most recently updated one if
+// no meeting was requested or it no longer exists
+func selectMeeting(meetings map[string]*MeetingData, meetingUUID string) (string, *MeetingData) {
+	if meeting, exists := meetings[meetingUUID]; exists {
+		return meetingUUID, meeting
+	}
+	return latestMeeting(meetings)
+}
+
+// meetingSummaries lists the given meetings, most recently updated first
+func meetingSummaries(meetings map[string]*MeetingData) []MeetingSummary {
+	summaries := make([]MeetingSummary, 0, len(meetings))
+	for uuid, meeting := range meetings {
+		summaries = append(summaries, MeetingSummary{
+			UUID:             uuid,
+			ID:               meeting.ID,
+			Topic:            meeting.Topic,
+			ParticipantCount: len(meeting.presentNames()),
+			Ended:            meeting.Ended,
+			LastUpdated:      meeting.LastUpdated,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].LastUpdated.After(summaries[j].LastUpdated)
+	})
+	return summaries
+}
+
+// viewParticipantsHandler displays the participant list or password prompt
+func (h *handlers) viewParticipantsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	if r.Method == "POST" {
-		viewerPassword := r.FormValue("password")
-		appState.PasswordMutex.RLock()
-		if accID, exists := appState.PasswordToAccountID[viewerPassword]; exists {
-			authenticated = true
-			accountID = accID
+		accountID, errorMessage := authenticateViewer(r, r.FormValue("password"))
+		if accountID == "" {
+			renderError(w, errorMessage)
+			return
 		}
-		appState.PasswordMutex.RUnlock()
-
-		if !authenticated {
-			// Fallback to database check
-			var dbAccountID string
-			err := appState.DB.QueryRow("SELECT account_id FROM accounts WHERE viewer_password = ?", viewerPassword).Scan(&dbAccountID)
-			if err == nil {
-				authenticated = true
-				accountID = dbAccountID
-				appState.PasswordMutex.Lock()
-				appState.PasswordToAccountID[viewerPassword] = accountID
-				appState.PasswordMutex.Unlock()
-			} else if !errors.Is(err, sql.ErrNoRows) {
-				errorMessage = "Datenbankfehler bei der Authentifizierung."
-			} else {
-				errorMessage = "Falsches Passwort."
-			}
+		if err := issueSession(w, r, accountID); err != nil {
+			renderError(w, "Datenbankfehler bei der Authentifizierung.")
+			return
 		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
 
-	if authenticated {
-		accountMutex, exists := appState.AccountMutexes[accountID]
-		if exists {
-			accountMutex.RLock()
-			defer accountMutex.RUnlock()
-
-			// Get the latest meeting
-			var latestMeeting *MeetingData
-			var latestUUID string
-			for uuid, meeting := range appState.Meetings[accountID] {
-				if latestMeeting == nil || meeting.LastUpdated.After(latestMeeting.LastUpdated) {
-					latestMeeting = meeting
-					latestUUID = uuid
-				}
-			}
+	accountID, authenticated := sessionAccount(r)
+	if !authenticated {
+		renderTemplate(w, pageData{})
+		return
+	}
+
+	data := pageData{Authenticated: true, Retention: retentionFor(accountID), MaxRetention: meetingRetention}
+	h.store.View(accountID, func(meetings map[string]*MeetingData) {
+		meetingUUID, meeting := selectMeeting(meetings, r.URL.Query().Get("meeting"))
+		if meeting == nil {
+			return
+		}
+		names := meeting.presentNames()
+		data.Participants = names
+		data.ParticipantCount = len(names)
+		data.Waiting = sortedNames(meeting.Waiting)
+		data.Rooms = meeting.breakoutRooms()
+		data.Meetings = meetingSummaries(meetings)
+		data.MeetingUUID = meetingUUID
+		data.MeetingTopic = meeting.Topic
+		data.MeetingEnded = meeting.Ended
+		data.Updated = meeting.LastUpdated.Format("2006-01-02 15:04:05")
+		log.Printf("Displaying participants for meeting: %s", meetingUUID)
+	})
+	renderTemplate(w, data)
+}
 
-			if latestMeeting != nil {
-				names := make([]string, 0, len(latestMeeting.Participants))
-				for _, name := range latestMeeting.Participants {
-					names = append(names, name)
-				}
-				sort.Strings(names)
+// sortedNames returns the display names of the given participant map in alphabetical order
+func sortedNames(participants map[string]string) []string {
+	names := make([]string, 0, len(participants))
+	for _, name := range participants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// roomName returns the display name of a breakout room, numbered in order of first use
+func (m *MeetingData) roomName(roomUUID string) string {
+	return fmt.Sprintf("Raum %d", slices.Index(m.RoomOrder, roomUUID)+1)
+}
 
-				renderTemplate(w, true, names, len(names), latestMeeting.Topic, r.FormValue("password"), "", latestMeeting.LastUpdated.Format("2006-01-02 15:04:05"))
-				log.Printf("Displaying participants for meeting: %s", latestUUID)
-				return
+// breakoutRooms groups the participants by breakout room, numbering the rooms in order of first use.
+// Rooms without participants are omitted. The caller must hold the account mutex.
+func (m *MeetingData) breakoutRooms() []BreakoutRoom {
+	rooms := make([]BreakoutRoom, 0, len(m.RoomOrder))
+	for _, roomUUID := range m.RoomOrder {
+		var names []string
+		for uniqueID, room := range m.Rooms {
+			if room == roomUUID {
+				names = append(names, m.Participants[uniqueID].Name)
 			}
 		}
+		if len(names) == 0 {
+			continue
+		}
+		sort.Strings(names)
+		rooms = append(rooms, BreakoutRoom{
+			Name:         m.roomName(roomUUID),
+			Participants: names,
+		})
 	}
+	return rooms
+}
 
-	renderTemplate(w, authenticated, nil, 0, "", r.FormValue("password"), errorMessage, "")
+// pageData holds the values rendered into the HTML template
+type pageData struct {
+	Authenticated    bool
+	Demo             bool // Set on the demo page, which has no meeting on the server
+	Participants     []string
+	ParticipantCount int
+	Waiting          []string
+	Rooms            []BreakoutRoom
+	Meetings         []MeetingSummary
+	MeetingUUID      string
+	MeetingTopic     string
+	MeetingEnded     bool
+	Retention        RetentionPolicy
+	MaxRetention     time.Duration
+	ErrorMessage     string
+	InfoMessage      string
+	Updated          string
 }
 
 // renderTemplate renders the HTML template with the given data
-func renderTemplate(w http.ResponseWriter, authenticated bool, participants []string, count int, topic, password, errorMsg, updated string) {
-	data := struct {
-		Authenticated    bool
-		Participants     []string
-		ParticipantCount int
-		MeetingTopic     string
-		Password         string
-		ErrorMessage     string
-		Updated          string
-	}{
-		Authenticated:    authenticated,
-		Participants:     participants,
-		ParticipantCount: count,
-		MeetingTopic:     topic,
-		Password:         password,
-		ErrorMessage:     errorMsg,
-		Updated:          updated,
-	}
-
+func renderTemplate(w http.ResponseWriter, data pageData) {
 	w.Header().Set("Content-Type", "text/html")
 	if err := tmpl.Execute(w, data); err != nil {
 		http.Error(w, "Fehler beim Rendern der Seite", http.StatusInternalServerError)
@@ -376,82 +691,102 @@ func renderTemplate(w http.ResponseWriter, authenticated bool, participants []st
 
 // renderError renders an error message in the HTML template
 func renderError(w http.ResponseWriter, errorMsg string) {
-	renderTemplate(w, false, nil, 0, "", "", errorMsg, "")
+	renderTemplate(w, pageData{ErrorMessage: errorMsg})
 }
 
-// cleanupOldMeetings removes meeting data older than 6 hours
-func cleanupOldMeetings() {
+// cleanupOldMeetings periodically removes meetings that the retention policy of their account no
+// longer allows to keep. The store drops accounts left without meetings. Draws whose winners were
+// not sent, for example because the server restarted during the raffle animation, are revealed.
+func cleanupOldMeetings(store Store) {
 	for {
-		time.Sleep(time.Hour)
+		time.Sleep(cleanupInterval)
 
-		// Get list of account IDs without locking the password map for too long
-		appState.PasswordMutex.RLock()
-		accountIDs := make([]string, 0, len(appState.Meetings))
-		for accountID := range appState.Meetings {
-			accountIDs = append(accountIDs, accountID)
-		}
-		appState.PasswordMutex.RUnlock()
-
-		for _, accountID := range accountIDs {
-			accountMutex, exists := appState.AccountMutexes[accountID]
-			if exists {
-				accountMutex.Lock()
-				meetings := appState.Meetings[accountID]
-				for uuid, meeting := range meetings {
-					if time.Since(meeting.LastUpdated) > 6*time.Hour {
-						delete(meetings, uuid)
-						log.Printf("Cleaned up old meeting: %s for account: %s", uuid, accountID)
-					}
-				}
-				if len(meetings) == 0 {
-					// Clean up empty account data
-					delete(appState.Meetings, accountID)
-					delete(appState.AccountMutexes, accountID)
-					log.Printf("Removed empty account data for: %s", accountID)
-				}
-				accountMutex.Unlock()
+		now := time.Now()
+		for _, accountID := range store.Accounts() {
+			if err := revealDueDraws(store, accountID, now); err != nil {
+				log.Printf("Failed to reveal draws of account %s: %v", accountID, err)
+			}
+			if err := purgeExpiredMeetings(store, accountID, now); err != nil {
+				log.Printf("Failed to remove expired meetings of account %s: %v", accountID, err)
 			}
 		}
 	}
 }
 
+// handlers holds the dependencies of the HTTP handlers that work with meetings
+type handlers struct {
+	store Store
+}
+
 // SetupHandlers sets up the HTTP routes
-func SetupHandlers(router *httprouter.Router, db *sql.DB) {
+func SetupHandlers(router *httprouter.Router, db *sql.DB, store Store) {
 	appState.DB = db
-	router.POST("/webhook", webhookHandler)
-	router.GET("/", viewParticipantsHandler)
-	router.POST("/", viewParticipantsHandler)
-	router.GET("/ws", wsHandler)
+	h := &handlers{store: store}
+	router.POST("/webhook", h.webhookHandler)
+	router.GET("/", h.viewParticipantsHandler)
+	router.POST("/", h.viewParticipantsHandler)
+	router.GET("/ws", h.wsHandler)
+	router.GET("/sse", h.sseHandler)
 	router.POST("/add-account", addAccountHandler)
+	router.POST("/account/verify", verifyAccountHandler)
+	router.POST("/account/update", updateAccountHandler)
+	router.POST("/account/delete", h.deleteAccountHandler)
+	router.POST("/account/retention", h.updateRetentionHandler)
+	router.POST("/logout", logoutHandler)
+	router.GET("/export", h.exportHandler)
+	router.GET("/metrics", metricsHandler)
+	router.GET("/api/v1/meetings", h.apiListMeetingsHandler)
+	router.GET("/api/v1/meetings/*uuid", h.apiGetMeetingHandler)
+	router.GET("/api/v1/counts", h.apiCountsHandler)
+	router.GET("/api/v1/draws", h.apiListDrawsHandler)
+	router.POST("/api/v1/draws", h.apiDrawHandler)
+	router.GET("/api/v1/openapi.yaml", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Header().Set("Content-Type", "application/yaml")
+		http.ServeFile(w, r, "openapi.yaml")
+	})
 	router.GET("/test", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		renderTemplate(w, true, []string{
-			"Alice Smith",
-			"Bob Johnson",
-			"Charlie Brown",
-			"David Wilson",
-			"Eve Davis",
-			"Frank Miller",
-			"Grace Lee",
-			"Hannah Garcia",
-			"Ian Martinez",
-			"Jack Taylor",
-			"Kate Anderson",
-			"Liam Thomas",
-			"Mia Jackson",
-			"Noah White",
-			"Olivia Harris",
-			"Paul Clark",
-			"Quinn Lewis",
-			"Rachel Walker",
-			"Sam Hall",
-			"Tina Young",
-			"Uma King",
-			"Vera Wright",
-			"Walter Scott",
-			"Xander Green",
-			"Yara Adams",
-			"Zoe Baker",
-		}, 26, "Simulated Demo", "", "", time.Now().Format("2006-01-02 15:04:05"))
+		renderTemplate(w, pageData{
+			Authenticated: true,
+			Demo:          true,
+			Participants: []string{
+				"Alice Smith",
+				"Bob Johnson",
+				"Charlie Brown",
+				"David Wilson",
+				"Eve Davis",
+				"Frank Miller",
+				"Grace Lee",
+				"Hannah Garcia",
+				"Ian Martinez",
+				"Jack Taylor",
+				"Kate Anderson",
+				"Liam Thomas",
+				"Mia Jackson",
+				"Noah White",
+				"Olivia Harris",
+				"Paul Clark",
+				"Quinn Lewis",
+				"Rachel Walker",
+				"Sam Hall",
+				"Tina Young",
+				"Uma King",
+				"Vera Wright",
+				"Walter Scott",
+				"Xander Green",
+				"Yara Adams",
+				"Zoe Baker",
+			},
+			ParticipantCount: 26,
+			Waiting:          []string{"Anna Berg", "Ben Vogel"},
+			Rooms: []BreakoutRoom{
+				{Name: "Raum 1", Participants: []string{"Alice Smith", "Bob Johnson", "Charlie Brown"}},
+				{Name: "Raum 2", Participants: []string{"David Wilson", "Eve Davis"}},
+			},
+			MeetingTopic: "Simulated Demo",
+			Retention:    defaultRetention,
+			MaxRetention: meetingRetention,
+			Updated:      time.Now().Format("2006-01-02 15:04:05"),
+		})
 	})
 	router.GET("/random-js.min.js", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		w.Header().Set("Content-Type", "application/javascript")
@@ -462,6 +797,8 @@ func SetupHandlers(router *httprouter.Router, db *sql.DB) {
 		http.ServeFile(w, r, "workshop.png")
 	})
 
-	// Start cleanup routine
-	go cleanupOldMeetings()
+	// Start cleanup routines
+	go cleanupOldMeetings(store)
+	go cleanupPendingAccounts()
+	go reapViewers()
 }
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Updates queued per viewer. A viewer falling further behind is disconnected and resumes when it reconnects.
+const viewerQueueSize = 64
+
+// Time after which a viewer that has not answered a ping or accepted a heartbeat is considered dead
+const viewerTimeout = time.Minute
+
+// Interval of the reaper that disconnects dead viewers, also on accounts without any updates
+const reapInterval = 30 * time.Second
+
+// viewer is one WebSocket or SSE connection receiving the updates of an account. Broadcasts only
+// queue updates, the viewer's own goroutine performs the network writes.
+type viewer struct {
+	transport   string        // "ws" or "sse"
+	meetingUUID string        // Meeting the viewer is subscribed to, empty while the account has no meetings
+	version     int           // Protocol version of the messages
+	backlog     []update      // Messages bringing the viewer up to date, sent before the queue
+	synced      uint64        // Sequence number the backlog brings the viewer to, older updates arriving from other instances are skipped
+	queue       chan update   // Updates published after the viewer subscribed
+	notified    atomic.Bool   // Set once a viewer without a meeting was told that a meeting started
+	done        chan struct{} // Closed when the viewer is disconnected
+	closeOnce   sync.Once
+	closeCode   int // WebSocket close code sent when disconnecting
+	closeText   string
+	lastSeen    atomic.Int64 // Unix nanoseconds of the last sign of life of the connection
+}
+
+// newViewer creates a viewer of the given meeting
+func newViewer(transport, meetingUUID string, version int) *viewer {
+	v := &viewer{
+		transport:   transport,
+		meetingUUID: meetingUUID,
+		version:     version,
+		queue:       make(chan update, viewerQueueSize),
+		done:        make(chan struct{}),
+	}
+	v.touch()
+	return v
+}
+
+// touch records that the connection of the viewer is alive
+func (v *viewer) touch() {
+	v.lastSeen.Store(clock().UnixNano())
+}
+
+// disconnect tells the viewer's goroutine to close the connection with the given close code and reason
+func (v *viewer) disconnect(code int, text string) {
+	v.closeOnce.Do(func() {
+		v.closeCode = code
+		v.closeText = text
+		close(v.done)
+	})
+}
+
+// Map of accountID to connected viewers
+var viewers = struct {
+	sync.RWMutex
+	byAccount map[string]map[*viewer]struct{}
+}{byAccount: make(map[string]map[*viewer]struct{})}
+
+// subscribe registers a viewer and determines the messages it missed since the given sequence
+// number. Broadcasts happen within Store.Update, so registering within Store.View ensures that no
+// update is missed or sent twice. A viewer that did not ask for a meeting follows the one it is
+// shown, the most recently updated.
+func subscribe(store Store, accountID, since string, v *viewer) {
+	store.View(accountID, func(meetings map[string]*MeetingData) {
+		v.backlog = catchUp(meetings, v.meetingUUID, since, v.version)
+		if v.meetingUUID == "" {
+			if latestUUID, latest := latestMeeting(meetings); latest != nil {
+				v.meetingUUID = latestUUID
+				v.synced = latest.Seq
+			}
+		} else if len(v.backlog) > 0 {
+			v.synced = v.backlog[len(v.backlog)-1].seq
+		} else {
+			// Nothing was missed, the viewer is up to date as of since
+			v.synced, _ = strconv.ParseUint(since, 10, 64)
+		}
+		viewers.Lock()
+		defer viewers.Unlock()
+		if viewers.byAccount[accountID] == nil {
+			viewers.byAccount[accountID] = make(map[*viewer]struct{})
+		}
+		viewers.byAccount[accountID][v] = struct{}{}
+	})
+}
+
+// removeViewer stops delivering updates to a viewer
+func removeViewer(accountID string, v *viewer) {
+	viewers.Lock()
+	defer viewers.Unlock()
+	if account, ok := viewers.byAccount[accountID]; ok {
+		delete(account, v)
+		if len(account) == 0 {
+			delete(viewers.byAccount, accountID)
+		}
+	}
+}
+
+// unsubscribe removes a viewer whose connection ended
+func unsubscribe(accountID string, v *viewer) {
+	removeViewer(accountID, v)
+	v.disconnect(websocket.CloseNormalClosure, "")
+}
+
+// closeConnections disconnects all viewers of an account, e.g. after its viewer password changed
+func closeConnections(accountID string) {
+	viewers.Lock()
+	account := viewers.byAccount[accountID]
+	delete(viewers.byAccount, accountID)
+	viewers.Unlock()
+
+	for v := range account {
+		v.disconnect(websocket.ClosePolicyViolation, "credentials changed")
+	}
+}
+
+// disconnectAll disconnects all viewers when the server shuts down. They reconnect once it is back.
+func disconnectAll() {
+	viewers.Lock()
+	all := viewers.byAccount
+	viewers.byAccount = make(map[string]map[*viewer]struct{})
+	viewers.Unlock()
+
+	for _, account := range all {
+		for v := range account {
+			v.disconnect(websocket.CloseServiceRestart, "server restart")
+		}
+	}
+}
+
+// publish queues an update for the viewers of a meeting without blocking. Viewers whose queue is
+// full are disconnected, so a slow connection never delays webhook processing. Viewers that
+// subscribed while the account had no meetings are only told once that a meeting started.
+func publish(accountID, meetingUUID string, u update) {
+	var slow []*viewer
+	viewers.RLock()
+	for v := range viewers.byAccount[accountID] {
+		message := u
+		if v.meetingUUID == "" {
+			if !v.notified.CompareAndSwap(false, true) {
+				continue
+			}
+			message = meetingStartedNotice(meetingUUID)
+		} else if v.meetingUUID != meetingUUID || u.seq <= v.synced {
+			continue
+		}
+		select {
+		case v.queue <- message:
+		default:
+			slow = append(slow, v)
+		}
+	}
+	viewers.RUnlock()
+
+	for _, v := range slow {
+		log.Printf("Disconnecting slow viewer of account: %s", accountID)
+		removeViewer(accountID, v)
+		v.disconnect(websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+// reapViewers periodically disconnects viewers whose connection died without being closed
+func reapViewers() {
+	for {
+		time.Sleep(reapInterval)
+
+		deadline := clock().Add(-viewerTimeout).UnixNano()
+		dead := make(map[*viewer]string)
+		viewers.RLock()
+		for accountID, account := range viewers.byAccount {
+			for v := range account {
+				if v.lastSeen.Load() < deadline {
+					dead[v] = accountID
+				}
+			}
+		}
+		viewers.RUnlock()
+
+		for v, accountID := range dead {
+			log.Printf("Disconnecting unresponsive viewer of account: %s", accountID)
+			removeViewer(accountID, v)
+			v.disconnect(websocket.CloseGoingAway, "timeout")
+		}
+	}
+}
+
+// viewerCounts returns the number of connected viewers per account and transport
+func viewerCounts() map[string]map[string]int {
+	viewers.RLock()
+	defer viewers.RUnlock()
+	counts := make(map[string]map[string]int, len(viewers.byAccount))
+	for accountID, account := range viewers.byAccount {
+		counts[accountID] = make(map[string]int)
+		for v := range account {
+			counts[accountID][v.transport]++
+		}
+	}
+	return counts
+}
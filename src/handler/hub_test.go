@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// isDisconnected reports whether the viewer was told to close its connection
+func isDisconnected(v *viewer) bool {
+	select {
+	case <-v.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// isSubscribed reports whether the viewer still receives the updates of the account
+func isSubscribed(accountID string, v *viewer) bool {
+	viewers.RLock()
+	defer viewers.RUnlock()
+	_, exists := viewers.byAccount[accountID][v]
+	return exists
+}
+
+func TestPublishEvictsSlowViewer(t *testing.T) {
+	resetState(nil)
+	store := newMemoryStore()
+	slow := newViewer("ws", "m1", protocolV2)
+	subscribe(store, "acc", "", slow)
+	fast := newViewer("sse", "m1", protocolV2)
+	subscribe(store, "acc", "", fast)
+
+	// Nobody reads the queue of the slow viewer, publishing must go on regardless. The fast viewer
+	// takes every update right away.
+	received := 0
+	published := make(chan struct{})
+	go func() {
+		for seq := uint64(1); seq <= 2*viewerQueueSize; seq++ {
+			publish("acc", "m1", update{seq: seq})
+			if u := <-fast.queue; u.seq == seq {
+				received++
+			}
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a full queue")
+	}
+
+	if !isDisconnected(slow) || slow.closeCode != websocket.CloseTryAgainLater {
+		t.Errorf("slow viewer: disconnected %v with code %d", isDisconnected(slow), slow.closeCode)
+	}
+	if isSubscribed("acc", slow) {
+		t.Error("slow viewer is still subscribed")
+	}
+	if received != 2*viewerQueueSize || isDisconnected(fast) {
+		t.Errorf("fast viewer: received %d updates, disconnected %v", received, isDisconnected(fast))
+	}
+}
+
+func TestViewerWithoutMeeting(t *testing.T) {
+	resetState(nil)
+	store := newMemoryStore()
+	join := func(meetingUUID, id string) {
+		t.Helper()
+		err := store.Update("acc", func(meetings map[string]*MeetingData) {
+			meeting := meetings[meetingUUID]
+			if meeting == nil {
+				meeting = &MeetingData{Participants: make(map[string]*Participant)}
+				meetings[meetingUUID] = meeting
+			}
+			meeting.LastUpdated = time.Now()
+			broadcastJoined("acc", meetingUUID, meeting, id, "Participant "+id)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Before the account has meetings, a viewer only learns that one started
+	early := newViewer("ws", "", protocolV2)
+	subscribe(store, "acc", "", early)
+	join("m1", "1")
+	join("m1", "2")
+	if len(early.queue) != 1 {
+		t.Fatalf("viewer without meeting received %d messages, want 1", len(early.queue))
+	}
+	if u := <-early.queue; u.seq != 0 || !strings.Contains(string(u.v2), `"type":"meeting_started"`) || !strings.Contains(string(u.v2), `"meeting":"m1"`) {
+		t.Errorf("notice: %s", u.v2)
+	}
+
+	// Later the viewer follows the meeting it was shown and no other one
+	late := newViewer("sse", "", protocolV1)
+	subscribe(store, "acc", "", late)
+	join("m2", "3")
+	join("m1", "4")
+	if len(early.queue) != 0 {
+		t.Errorf("viewer without meeting received %d further messages", len(early.queue))
+	}
+	if late.meetingUUID != "m1" || len(late.queue) != 1 {
+		t.Fatalf("viewer of %q received %d updates, want 1 of m1", late.meetingUUID, len(late.queue))
+	}
+	if u := <-late.queue; u.seq != 3 {
+		t.Errorf("viewer received seq %d, want 3", u.seq)
+	}
+}
+
+func TestSubscribeDuringUpdate(t *testing.T) {
+	resetState(nil)
+	store := newMemoryStore()
+	// Less than a queue full, so the viewers need not be read while the updates happen
+	const updates = viewerQueueSize - 4
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= updates; i++ {
+			err := store.Update("acc", func(meetings map[string]*MeetingData) {
+				meeting := meetings["m1"]
+				if meeting == nil {
+					meeting = &MeetingData{Participants: make(map[string]*Participant)}
+					meetings["m1"] = meeting
+				}
+				broadcastJoined("acc", "m1", meeting, strconv.Itoa(i), "Participant "+strconv.Itoa(i))
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	subscribed := make([]*viewer, 32)
+	for i := range subscribed {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v := newViewer("ws", "m1", protocolV2)
+			subscribe(store, "acc", "", v)
+			subscribed[i] = v
+		}()
+	}
+	wg.Wait()
+
+	// Every viewer continues exactly after the snapshot it received, up to the last update
+	for i, v := range subscribed {
+		seq := v.backlog[len(v.backlog)-1].seq
+		for len(v.queue) > 0 {
+			u := <-v.queue
+			if u.seq != seq+1 {
+				t.Fatalf("viewer %d: got seq %d after %d", i, u.seq, seq)
+			}
+			seq = u.seq
+		}
+		if seq != updates {
+			t.Errorf("viewer %d: last seq %d, want %d", i, seq, updates)
+		}
+	}
+}
+
+func TestDisconnectRace(t *testing.T) {
+	resetState(nil)
+	store := newMemoryStore()
+	subscribed := make([]*viewer, 32)
+	for i := range subscribed {
+		subscribed[i] = newViewer("ws", "", protocolV1)
+		subscribe(store, "acc", "", subscribed[i])
+	}
+
+	// The connection ending, the credentials changing, a slow viewer being evicted and the server
+	// shutting down may all happen at once
+	var wg sync.WaitGroup
+	for _, v := range subscribed {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			unsubscribe("acc", v)
+		}()
+		go func() {
+			defer wg.Done()
+			v.disconnect(websocket.CloseGoingAway, "timeout")
+		}()
+	}
+	for range 4 {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			closeConnections("acc")
+		}()
+		go func() {
+			defer wg.Done()
+			for seq := uint64(1); seq <= 2*viewerQueueSize; seq++ {
+				publish("acc", "", update{seq: seq})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			disconnectAll()
+		}()
+	}
+	wg.Wait()
+
+	for i, v := range subscribed {
+		if !isDisconnected(v) || isSubscribed("acc", v) {
+			t.Errorf("viewer %d: disconnected %v, subscribed %v", i, isDisconnected(v), isSubscribed("acc", v))
+		}
+	}
+	if counts := viewerCounts(); len(counts) != 0 {
+		t.Errorf("viewers left: %v", counts)
+	}
+}
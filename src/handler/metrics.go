@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// metricsToken unlocks the metrics per account, which reveal the account IDs, see Config
+var metricsToken string
+
+// metricsAuthorized reports whether the request carries the metrics token
+func metricsAuthorized(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && metricsToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(metricsToken)) == 1
+}
+
+// metricsHandler exposes operational counters in the Prometheus text format. Anyone may read the
+// totals, the metrics per account need the metrics token.
+func metricsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP zoom_blocked_login_attempts_total Password attempts rejected by the rate limiter.")
+	fmt.Fprintln(w, "# TYPE zoom_blocked_login_attempts_total counter")
+	fmt.Fprintf(w, "zoom_blocked_login_attempts_total %d\n", blockedLoginAttempts.Load())
+
+	counts := viewerCounts()
+	totals := make(map[string]int)
+	for _, account := range counts {
+		for transport, count := range account {
+			totals[transport] += count
+		}
+	}
+	fmt.Fprintln(w, "# HELP zoom_live_viewers Open viewer connections per transport.")
+	fmt.Fprintln(w, "# TYPE zoom_live_viewers gauge")
+	for _, transport := range []string{"ws", "sse"} {
+		fmt.Fprintf(w, "zoom_live_viewers{transport=%q} %d\n", transport, totals[transport])
+	}
+	if !metricsAuthorized(r) {
+		return
+	}
+
+	fmt.Fprintln(w, "# HELP zoom_account_live_viewers Open viewer connections per account and transport.")
+	fmt.Fprintln(w, "# TYPE zoom_account_live_viewers gauge")
+	accountIDs := make([]string, 0, len(counts))
+	for accountID := range counts {
+		accountIDs = append(accountIDs, accountID)
+	}
+	slices.Sort(accountIDs)
+	for _, accountID := range accountIDs {
+		for _, transport := range []string{"ws", "sse"} {
+			fmt.Fprintf(w, "zoom_account_live_viewers{account=%s,transport=%q} %d\n", strconv.Quote(accountID), transport, counts[accountID][transport])
+		}
+	}
+}
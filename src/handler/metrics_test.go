@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHideAccounts(t *testing.T) {
+	resetState(nil)
+	previous := metricsToken
+	metricsToken = "metricstoken"
+	t.Cleanup(func() { metricsToken = previous })
+	subscribe(newMemoryStore(), "secret-account", "", newViewer("ws", "", protocolV1))
+
+	tests := []struct {
+		name     string
+		header   string
+		accounts bool
+	}{
+		{"without token", "", false},
+		{"wrong token", "Bearer wrong", false},
+		{"metrics token", "Bearer metricstoken", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			metricsHandler(w, r, nil)
+			body := w.Body.String()
+			if !strings.Contains(body, `zoom_live_viewers{transport="ws"} 1`) {
+				t.Errorf("total missing:\n%s", body)
+			}
+			if strings.Contains(body, "secret-account") != tt.accounts {
+				t.Errorf("account listed: got %v, want %v\n%s", !tt.accounts, tt.accounts, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration upgrades the database schema to its version
+type migration struct {
+	version int
+	name    string
+	apply   func(tx *sql.Tx) error
+}
+
+// goMigrations holds migrations that need more than SQL, such as converting existing rows
+var goMigrations = []migration{
+	{version: 2, name: "0002_encrypt_credentials", apply: migratePlaintextCredentials},
+}
+
+// loadMigrations returns all migrations ordered by version. The embedded SQL files are named
+// NNNN_description.sql, and versions must be consecutive starting at 1.
+func loadMigrations() ([]migration, error) {
+	migrations := append([]migration(nil), goMigrations...)
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		content, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		statements := string(content)
+		migrations = append(migrations, migration{
+			version: version,
+			name:    name,
+			apply: func(tx *sql.Tx) error {
+				_, err := tx.Exec(statements)
+				return err
+			},
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %s has version %d, expected %d", m.name, m.version, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// migrateDB applies all pending migrations, each in its own transaction. The schema version is kept
+// in SQLite's user_version. Databases with a version newer than this binary knows are rejected.
+func migrateDB(db *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&current); err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the supported version %d", current, len(migrations))
+	}
+
+	for _, m := range migrations[current:] {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.apply(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %v", m.name, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Applied database migration %s", m.name)
+	}
+	return nil
+}
+
+// migratePlaintextCredentials converts an accounts table with plaintext secret tokens and viewer
+// passwords into one with encrypted secret tokens and hashed viewer passwords
+func migratePlaintextCredentials(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT account_id, secret_token, viewer_password FROM accounts")
+	if err != nil {
+		return err
+	}
+	type legacyAccount struct{ accountID, secretToken, viewerPassword string }
+	var accounts []legacyAccount
+	for rows.Next() {
+		var account legacyAccount
+		if err := rows.Scan(&account.accountID, &account.secretToken, &account.viewerPassword); err != nil {
+			rows.Close()
+			return err
+		}
+		accounts = append(accounts, account)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DROP TABLE accounts"); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	CREATE TABLE accounts (
+		account_id TEXT PRIMARY KEY,
+		secret_token_enc TEXT NOT NULL,
+		viewer_lookup TEXT NOT NULL UNIQUE,
+		viewer_hash TEXT NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		encrypted, err := encryptSecret(account.accountID, account.secretToken)
+		if err != nil {
+			return err
+		}
+		hash, err := hashViewerPassword(account.viewerPassword)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO accounts (account_id, secret_token_enc, viewer_lookup, viewer_hash) VALUES (?, ?, ?, ?)`,
+			account.accountID, encrypted, viewerLookup(account.viewerPassword), hash)
+		if err != nil {
+			return err
+		}
+	}
+	if len(accounts) > 0 {
+		log.Printf("Encrypted credentials of %d existing accounts", len(accounts))
+	}
+	return nil
+}
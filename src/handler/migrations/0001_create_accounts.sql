@@ -0,0 +1,6 @@
+-- Initial schema. Databases created before versioning already have this table.
+CREATE TABLE IF NOT EXISTS accounts (
+	account_id TEXT PRIMARY KEY,
+	secret_token TEXT NOT NULL,
+	viewer_password TEXT NOT NULL UNIQUE
+);
@@ -0,0 +1,4 @@
+-- New accounts stay pending until a signed webhook proves ownership of the account ID.
+-- Accounts that existed before are considered verified.
+ALTER TABLE accounts ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE accounts ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
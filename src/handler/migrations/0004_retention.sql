@@ -0,0 +1,5 @@
+-- Per-account retention of participant data. Existing accounts keep meetings for the server's
+-- maximum retention, as before.
+ALTER TABLE accounts ADD COLUMN retention_mode TEXT NOT NULL DEFAULT 'max';
+ALTER TABLE accounts ADD COLUMN retention_minutes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE accounts ADD COLUMN memory_only INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+-- Meetings of the SQLite store, encrypted with a key derived from the server key. Accounts that
+-- keep names in memory only never have rows here.
+CREATE TABLE meetings (
+    account_id TEXT NOT NULL,
+    meeting_uuid TEXT NOT NULL,
+    data BLOB NOT NULL,
+    PRIMARY KEY (account_id, meeting_uuid)
+);
@@ -0,0 +1,15 @@
+-- Versions let instances sharing the database notice meetings changed by others. The bus carries
+-- updates and account changes between instances, its data is encrypted like the meetings.
+ALTER TABLE meetings ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+CREATE TABLE bus_messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    instance TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    account_id TEXT NOT NULL,
+    meeting_uuid TEXT NOT NULL DEFAULT '',
+    seq INTEGER NOT NULL DEFAULT 0,
+    data BLOB,
+    disconnect INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL
+);
+CREATE INDEX bus_messages_created_at ON bus_messages (created_at);
@@ -0,0 +1,5 @@
+-- Signatures of processed webhooks, so that instances sharing the database reject each other's replays
+CREATE TABLE webhook_signatures (
+    signature TEXT PRIMARY KEY,
+    expires_at INTEGER NOT NULL
+);
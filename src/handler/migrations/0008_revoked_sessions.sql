@@ -0,0 +1,6 @@
+-- Sessions ended by logging out, kept until they would have expired so that copies of the cookie
+-- are rejected as well
+CREATE TABLE revoked_sessions (
+    signature TEXT PRIMARY KEY,
+    expires_at INTEGER NOT NULL
+);
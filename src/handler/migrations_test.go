@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigratePlaintextCredentials(t *testing.T) {
+	if err := setServerKey(bytes.Repeat([]byte{7}, serverKeySize)); err != nil {
+		t.Fatal(err)
+	}
+	// A database of the version before migrations were introduced
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = legacy.Exec(`CREATE TABLE accounts (account_id TEXT PRIMARY KEY, secret_token TEXT NOT NULL, viewer_password TEXT NOT NULL UNIQUE);
+		INSERT INTO accounts VALUES ('acc', 'secretsecretsecret1', 'viewerpassword123')`)
+	legacy.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := InitDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	var encrypted, lookup, hash, status string
+	err = db.QueryRow("SELECT secret_token_enc, viewer_lookup, viewer_hash, status FROM accounts WHERE account_id = ?", "acc").Scan(&encrypted, &lookup, &hash, &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := decryptSecret("acc", encrypted); err != nil || secret != "secretsecretsecret1" {
+		t.Errorf("secret token: got %q, %v", secret, err)
+	}
+	if lookup != viewerLookup("viewerpassword123") || !verifyViewerPassword("viewerpassword123", hash) {
+		t.Error("viewer password was not converted")
+	}
+	if status != accountActive {
+		t.Errorf("existing account has status %s", status)
+	}
+}
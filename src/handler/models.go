@@ -9,16 +9,23 @@ import (
 // ZoomWebhookPayload represents the structure of incoming Zoom webhook events
 type ZoomWebhookPayload struct {
 	Event   string `json:"event"`
+	EventTS int64  `json:"event_ts"` // Milliseconds since the epoch
 	Payload struct {
 		AccountID string `json:"account_id"`
 		Object    struct {
-			ID          string `json:"id"`
-			UUID        string `json:"uuid"`
-			Topic       string `json:"topic"`
-			Participant struct {
-				UserID   string `json:"user_id"`
-				UserName string `json:"user_name"`
-				Email    string `json:"email"`
+			ID               string `json:"id"`
+			UUID             string `json:"uuid"`
+			BreakoutRoomUUID string `json:"breakout_room_uuid"`
+			Topic            string `json:"topic"`
+			Participant      struct {
+				UserID          string `json:"user_id"`
+				ParentUserID    string `json:"parent_user_id"`
+				ParticipantUUID string `json:"participant_uuid"`
+				UserName        string `json:"user_name"`
+				Email           string `json:"email"`
+				JoinTime        string `json:"join_time"`
+				LeaveTime       string `json:"leave_time"`
+				LeaveReason     string `json:"leave_reason"`
 			} `json:"participant"`
 		} `json:"object"`
 		PlainToken string `json:"plainToken"`
@@ -27,16 +34,66 @@ type ZoomWebhookPayload struct {
 
 // MeetingData holds participant data for a specific meeting
 type MeetingData struct {
-	Participants map[string]string // Key: UserID or Name, Value: Display Name
+	Participants map[string]*Participant // Key: Participant UUID, UserID or Name
+	Waiting      map[string]string       // Key: Participant UUID, UserID or Name, Value: Display Name of participants in the waiting room
+	Rooms        map[string]string       // Key: Participant UUID, UserID or Name, Value: UUID of the breakout room the participant is in
+	RoomOrder    []string                // Breakout room UUIDs in order of first appearance, used for numbering
+	Applied      map[string]EventStamp   // Key: Subject such as presence of a participant, Value: Last event applied to it
+	ID           string                  // Meeting number shown to users, shared by all occurrences of a recurring meeting
 	Topic        string
+	Ended        bool
+	EndedAt      time.Time
+	EndedTS      int64 // event_ts of meeting.ended, older participant events are discarded
 	LastUpdated  time.Time
+	Seq          uint64   // Sequence number of the last update sent to viewers
+	Draws        []Draw   // Raffle draws, oldest first
+	RaffleSeed   []byte   // Seed of the next draw, secret until the draw reveals it
+	RevealDraw   int      // Number of the draw whose winners are still to be sent to the viewers, 0 if none
+	history      []update // Most recent updates, replayed to viewers resuming a stream
+	unpublished  []update // Updates of the running Store.Update, published once the change is kept
+}
+
+// EventStamp identifies the last webhook event applied to a subject
+type EventStamp struct {
+	Event string
+	TS    int64
+}
+
+// MeetingSummary describes one meeting of an account for the meeting selector
+type MeetingSummary struct {
+	UUID             string
+	ID               string
+	Topic            string
+	ParticipantCount int
+	Ended            bool
+	LastUpdated      time.Time
+}
+
+// Participant holds the attendance record of one participant in a meeting
+type Participant struct {
+	UserID          string
+	ParticipantUUID string
+	Name            string
+	Email           string
+	Sessions        []Session // Ordered by join time, the last one is open while the participant is present
+}
+
+// Session is one continuous stay of a participant in a meeting
+type Session struct {
+	Joined time.Time
+	Left   time.Time // Zero while the session is still open
+}
+
+// BreakoutRoom lists the participants currently assigned to one breakout room
+type BreakoutRoom struct {
+	Name         string   `json:"name"`
+	Participants []string `json:"participants"`
 }
 
 // AppState holds the application state with thread-safe access
 type AppState struct {
-	Meetings            map[string]map[string]*MeetingData // Key: AccountID -> Meeting UUID -> MeetingData
-	AccountMutexes      map[string]*sync.RWMutex           // Key: AccountID -> Mutex for that account's meetings
-	PasswordToAccountID map[string]string                  // Key: ViewerPassword -> AccountID
-	PasswordMutex       sync.RWMutex                       // Dedicated mutex for password map
+	PasswordToAccountID map[string]string          // Key: Viewer password lookup identifier -> AccountID
+	Retention           map[string]RetentionPolicy // Key: AccountID -> Retention policy, guarded by PasswordMutex
+	PasswordMutex       sync.RWMutex               // Dedicated mutex for password map
 	DB                  *sql.DB
 }
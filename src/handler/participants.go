@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"sort"
+	"time"
+)
+
+// participantKey returns the identifier used to track a participant within a meeting.
+// The participant UUID stays the same when someone rejoins, so it is preferred over the user ID.
+func participantKey(payload ZoomWebhookPayload) string {
+	participant := payload.Payload.Object.Participant
+	if participant.ParticipantUUID != "" {
+		return participant.ParticipantUUID
+	}
+	if participant.UserID != "" {
+		return participant.UserID
+	}
+	return participant.UserName
+}
+
+// participantName returns the display name of the participant, falling back to "Anonymous"
+func participantName(payload ZoomWebhookPayload) string {
+	if name := payload.Payload.Object.Participant.UserName; name != "" {
+		return name
+	}
+	return "Anonymous"
+}
+
+// eventTime parses a join or leave time reported by Zoom, falling back to the current time
+func eventTime(value string) time.Time {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// keyFor returns the key of the participant addressed by the payload. Breakout room events
+// carry the participant's main meeting user ID in parent_user_id, which is resolved here.
+func (m *MeetingData) keyFor(payload ZoomWebhookPayload) string {
+	if parentUserID := payload.Payload.Object.Participant.ParentUserID; parentUserID != "" {
+		for key, participant := range m.Participants {
+			if participant.UserID == parentUserID {
+				return key
+			}
+		}
+	}
+	return participantKey(payload)
+}
+
+// participantFor returns the record of the participant addressed by the payload, creating it if necessary
+func (m *MeetingData) participantFor(payload ZoomWebhookPayload) *Participant {
+	key := m.keyFor(payload)
+	participant, exists := m.Participants[key]
+	if !exists {
+		participant = &Participant{}
+		m.Participants[key] = participant
+	}
+
+	data := payload.Payload.Object.Participant
+	participant.Name = participantName(payload)
+	if data.ParentUserID == "" && data.UserID != "" {
+		participant.UserID = data.UserID
+	}
+	if data.ParticipantUUID != "" {
+		participant.ParticipantUUID = data.ParticipantUUID
+	}
+	if data.Email != "" {
+		participant.Email = data.Email
+	}
+	return participant
+}
+
+// presentNames returns the display names of all participants currently in the meeting in alphabetical order
+func (m *MeetingData) presentNames() []string {
+	names := make([]string, 0, len(m.Participants))
+	for _, participant := range m.Participants {
+		if participant.Present() {
+			names = append(names, participant.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// join opens a new session and reports whether the participant was absent before
+func (p *Participant) join(at time.Time) bool {
+	if p.Present() {
+		return false
+	}
+	p.Sessions = append(p.Sessions, Session{Joined: at})
+	return true
+}
+
+// leave closes the open session and reports whether the participant was present before
+func (p *Participant) leave(at time.Time) bool {
+	if !p.Present() {
+		return false
+	}
+	session := &p.Sessions[len(p.Sessions)-1]
+	if at.Before(session.Joined) {
+		at = session.Joined
+	}
+	session.Left = at
+	return true
+}
+
+// Present reports whether the participant is currently in the meeting
+func (p *Participant) Present() bool {
+	return len(p.Sessions) > 0 && p.Sessions[len(p.Sessions)-1].Left.IsZero()
+}
+
+// TotalAttended returns the summed duration of all sessions, counting an open session until now
+func (p *Participant) TotalAttended(now time.Time) time.Duration {
+	var total time.Duration
+	for _, session := range p.Sessions {
+		left := session.Left
+		if left.IsZero() {
+			left = now
+		}
+		total += left.Sub(session.Joined)
+	}
+	return total
+}
+
+// Rejoins returns how many times the participant joined again after leaving
+func (p *Participant) Rejoins() int {
+	if len(p.Sessions) == 0 {
+		return 0
+	}
+	return len(p.Sessions) - 1
+}
+
+// FirstJoin returns the start of the first session
+func (p *Participant) FirstJoin() time.Time {
+	if len(p.Sessions) == 0 {
+		return time.Time{}
+	}
+	return p.Sessions[0].Joined
+}
+
+// LastLeave returns the end of the last session, or the zero time while the participant is present
+func (p *Participant) LastLeave() time.Time {
+	if len(p.Sessions) == 0 {
+		return time.Time{}
+	}
+	return p.Sessions[len(p.Sessions)-1].Left
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// Viewer protocol versions. Version 1 sends names only and stays the default for pages loaded
+// before version 2 existed. Version 2 numbers every message per meeting and identifies
+// participants by a stable ID, so reconnecting viewers can resume where they left off.
+const (
+	protocolV1 = 1
+	protocolV2 = 2
+)
+
+// viewerEntry is a participant as sent in protocol v2
+type viewerEntry struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// viewerRoom is a breakout room as sent in protocol v2
+type viewerRoom struct {
+	Name         string        `json:"name"`
+	Participants []viewerEntry `json:"participants"`
+}
+
+// protocolVersion returns the protocol requested with the v query parameter
+func protocolVersion(r *http.Request) int {
+	if r.URL.Query().Get("v") == "2" {
+		return protocolV2
+	}
+	return protocolV1
+}
+
+// participantID derives a stable ID from a participant key without revealing Zoom user IDs
+func participantID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// encodeV2 completes a protocol v2 message with its meeting and sequence number
+func encodeV2(meetingUUID string, seq uint64, message map[string]interface{}) []byte {
+	message["meeting"] = meetingUUID
+	message["seq"] = seq
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling %v message: %v", message["type"], err)
+	}
+	return data
+}
+
+// sortEntries orders entries by name, using the ID to keep equal names in a stable order
+func sortEntries(entries []viewerEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].ID < entries[j].ID
+	})
+}
+
+// presentEntries returns the participants currently in the meeting. The caller must hold the account mutex.
+func (m *MeetingData) presentEntries() []viewerEntry {
+	entries := make([]viewerEntry, 0, len(m.Participants))
+	for key, participant := range m.Participants {
+		if participant.Present() {
+			entries = append(entries, viewerEntry{ID: participantID(key), Name: participant.Name})
+		}
+	}
+	sortEntries(entries)
+	return entries
+}
+
+// waitingEntries returns the participants in the waiting room. The caller must hold the account mutex.
+func (m *MeetingData) waitingEntries() []viewerEntry {
+	entries := make([]viewerEntry, 0, len(m.Waiting))
+	for key, name := range m.Waiting {
+		entries = append(entries, viewerEntry{ID: participantID(key), Name: name})
+	}
+	sortEntries(entries)
+	return entries
+}
+
+// roomEntries groups the participants by breakout room like breakoutRooms, but with their IDs.
+// The caller must hold the account mutex.
+func (m *MeetingData) roomEntries() []viewerRoom {
+	rooms := make([]viewerRoom, 0, len(m.RoomOrder))
+	for _, roomUUID := range m.RoomOrder {
+		var entries []viewerEntry
+		for key, room := range m.Rooms {
+			if room == roomUUID {
+				entries = append(entries, viewerEntry{ID: participantID(key), Name: m.Participants[key].Name})
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		sortEntries(entries)
+		rooms = append(rooms, viewerRoom{Name: m.roomName(roomUUID), Participants: entries})
+	}
+	return rooms
+}
+
+// snapshotMessage describes the complete state of a meeting in protocol v2. The meeting may be nil
+// if it does not exist yet.
+func snapshotMessage(meetingUUID string, meeting *MeetingData) []byte {
+	message := map[string]interface{}{
+		"type":         "snapshot",
+		"participants": []viewerEntry{},
+		"waiting":      []viewerEntry{},
+		"rooms":        []viewerRoom{},
+		"ended":        false,
+	}
+	var seq uint64
+	if meeting != nil {
+		message["participants"] = meeting.presentEntries()
+		message["waiting"] = meeting.waitingEntries()
+		message["rooms"] = meeting.roomEntries()
+		message["ended"] = meeting.Ended
+		seq = meeting.Seq
+	}
+	return encodeV2(meetingUUID, seq, message)
+}
+
+// meetingStartedNotice tells a viewer without a meeting that the given meeting started. It carries
+// no participants, the viewer connects to the meeting to follow it.
+func meetingStartedNotice(meetingUUID string) update {
+	data, err := json.Marshal(map[string]string{"action": "meeting_started", "meeting": meetingUUID})
+	if err != nil {
+		log.Printf("Error marshaling meeting notice: %v", err)
+	}
+	return update{data: data, v2: encodeV2(meetingUUID, 0, map[string]interface{}{"type": "meeting_started"})}
+}
+
+// catchUp returns the messages that bring a viewer up to date: the changes after since if they
+// are still kept, otherwise the complete state. In protocol v2 the complete state is preceded by
+// a resync message if the viewer asked to resume.
+func catchUp(meetings map[string]*MeetingData, meetingUUID, since string, version int) []update {
+	// Sequence numbers are per meeting, so only viewers of a single meeting can resume
+	meeting, exists := meetings[meetingUUID]
+	if exists && since != "" {
+		if seq, err := strconv.ParseUint(since, 10, 64); err == nil {
+			if missed, ok := meeting.updatesSince(seq); ok {
+				return append([]update(nil), missed...)
+			}
+		}
+	}
+
+	if version == protocolV2 {
+		var updates []update
+		if since != "" {
+			resync := encodeV2(meetingUUID, 0, map[string]interface{}{"type": "resync"})
+			updates = append(updates, update{v2: resync})
+		}
+		selectedUUID, selected := meetingUUID, meeting
+		if meetingUUID == "" {
+			selectedUUID, selected = latestMeeting(meetings)
+		}
+		snapshot := update{v2: snapshotMessage(selectedUUID, selected)}
+		if selected != nil {
+			snapshot.seq = selected.Seq
+		}
+		return append(updates, snapshot)
+	}
+
+	var seq uint64
+	if exists {
+		seq = meeting.Seq
+	}
+	_, selected := selectMeeting(meetings, meetingUUID)
+	return []update{{seq: seq, data: resetMessage(selected)}}
+}
@@ -0,0 +1,332 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Draws kept per meeting, older ones are dropped
+const maxDraws = 100
+
+// Limits of a draw request
+const (
+	maxDrawWinners    = 100
+	maxClientSeedSize = 256
+	maxDrawBodySize   = 64 << 10
+	maxRaffleDuration = 30 * time.Second
+)
+
+// Time between the start of the raffle animation and the result, unless the request sets it
+const defaultRaffleDuration = 5 * time.Second
+
+var (
+	errCommitmentChanged = errors.New("the commitment of the next draw has changed")
+	errTooFewCandidates  = errors.New("not enough candidates")
+	errRaffleRunning     = errors.New("the previous draw is not revealed yet")
+)
+
+// Draw is one raffle draw of a meeting. The winners follow from the seed, the client seed and the
+// candidates as described in openapi.yaml, so anyone can verify them once the seed is revealed.
+type Draw struct {
+	Number     int           `json:"number"`
+	DrawnAt    time.Time     `json:"drawn_at"`
+	RevealAt   time.Time     `json:"reveal_at"`   // When viewers see the winners, after the raffle animation
+	Commitment string        `json:"commitment"`  // SHA-256 of the seed, published before the draw
+	Seed       string        `json:"seed"`        // Hex encoded, revealed by the draw
+	ClientSeed string        `json:"client_seed"` // Chosen by whoever requested the draw after seeing the commitment
+	Candidates []viewerEntry `json:"candidates"`  // Eligible participants in the order the winners are picked from
+	Excluded   []string      `json:"excluded"`    // IDs of present participants left out
+	Winners    []viewerEntry `json:"winners"`
+}
+
+// drawRequest describes the draw requested by a viewer
+type drawRequest struct {
+	Meeting            string   `json:"meeting"`
+	Count              int      `json:"count"`
+	Exclude            []string `json:"exclude"` // Participant IDs or names
+	WithoutReplacement bool     `json:"without_replacement"`
+	ClientSeed         string   `json:"client_seed"`
+	Commitment         string   `json:"commitment"`
+	Duration           int      `json:"duration"` // Seconds of the raffle animation
+}
+
+type apiDraws struct {
+	Meeting    string  `json:"meeting"`
+	Commitment *string `json:"commitment"`
+	Draws      []Draw  `json:"draws"`
+}
+
+type apiDrawResult struct {
+	Draw           *Draw  `json:"draw"`
+	NextCommitment string `json:"next_commitment"`
+}
+
+// newRaffleSeed returns the secret seed of the next draw
+func newRaffleSeed() []byte {
+	seed := make([]byte, 32)
+	// crypto/rand does not fail since Go 1.24
+	_, _ = rand.Read(seed)
+	return seed
+}
+
+// raffleCommitment publishes a seed without revealing it
+func raffleCommitment(seed []byte) string {
+	sum := sha256.Sum256(seed)
+	return hex.EncodeToString(sum[:])
+}
+
+// drawWinners picks count distinct winners from the candidates. The k-th random number is the
+// first 8 bytes of HMAC-SHA256(seed, clientSeed + ":" + k), read big-endian.
+func drawWinners(seed []byte, clientSeed string, candidates []viewerEntry, count int) []viewerEntry {
+	remaining := append([]viewerEntry(nil), candidates...)
+	winners := make([]viewerEntry, 0, count)
+	mac := hmac.New(sha256.New, seed)
+	for k := 0; len(winners) < count; k++ {
+		mac.Reset()
+		mac.Write([]byte(clientSeed + ":" + strconv.Itoa(k)))
+		x := binary.BigEndian.Uint64(mac.Sum(nil))
+
+		// Numbers above the last multiple of n would favor the first candidates
+		n := uint64(len(remaining))
+		if x > math.MaxUint64-(math.MaxUint64%n+1)%n {
+			continue
+		}
+		i := x % n
+		winners = append(winners, remaining[i])
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+	return winners
+}
+
+// draw picks winners among the present participants, reveals the seed and commits to the seed of
+// the next draw. The caller must hold the account mutex for writing.
+func (m *MeetingData) draw(req drawRequest, now time.Time) (*Draw, error) {
+	if len(m.Draws) > 0 && m.Draws[len(m.Draws)-1].RevealAt.After(now) {
+		return nil, errRaffleRunning
+	}
+	if len(m.RaffleSeed) == 0 {
+		m.RaffleSeed = newRaffleSeed()
+	}
+	commitment := raffleCommitment(m.RaffleSeed)
+	if req.Commitment != "" && req.Commitment != commitment {
+		return nil, errCommitmentChanged
+	}
+
+	excluded := make(map[string]bool)
+	for _, entry := range req.Exclude {
+		excluded[entry] = true
+	}
+	if req.WithoutReplacement {
+		for _, earlier := range m.Draws {
+			for _, winner := range earlier.Winners {
+				excluded[winner.ID] = true
+			}
+		}
+	}
+	candidates := []viewerEntry{}
+	excludedIDs := []string{}
+	for _, entry := range m.presentEntries() {
+		if excluded[entry.ID] || excluded[entry.Name] {
+			excludedIDs = append(excludedIDs, entry.ID)
+		} else {
+			candidates = append(candidates, entry)
+		}
+	}
+	if len(candidates) < req.Count {
+		return nil, errTooFewCandidates
+	}
+
+	d := Draw{
+		Number:     1,
+		DrawnAt:    now,
+		RevealAt:   now.Add(time.Duration(req.Duration) * time.Second),
+		Commitment: commitment,
+		Seed:       hex.EncodeToString(m.RaffleSeed),
+		ClientSeed: req.ClientSeed,
+		Candidates: candidates,
+		Excluded:   excludedIDs,
+		Winners:    drawWinners(m.RaffleSeed, req.ClientSeed, candidates, req.Count),
+	}
+	if len(m.Draws) > 0 {
+		d.Number = m.Draws[len(m.Draws)-1].Number + 1
+	}
+	if len(m.Draws) == maxDraws {
+		m.Draws = append(m.Draws[:0], m.Draws[1:]...)
+	}
+	m.Draws = append(m.Draws, d)
+	m.RaffleSeed = newRaffleSeed()
+	m.RevealDraw = d.Number
+	return &d, nil
+}
+
+// concealed returns the draw without the seed and winners while the viewers still watch the
+// raffle animation, so nobody learns the outcome before them
+func (d Draw) concealed(now time.Time) Draw {
+	if d.RevealAt.After(now) {
+		d.Seed = ""
+		d.Winners = []viewerEntry{}
+	}
+	return d
+}
+
+// revealDueDraws sends the winners of draws whose raffle animation is over. The drawing instance
+// calls it when the animation ends, and every instance checks again periodically and when viewers
+// connect, so a restart or another instance serving the viewers does not leave them waiting.
+func revealDueDraws(store Store, accountID string, now time.Time) error {
+	due := false
+	store.View(accountID, func(meetings map[string]*MeetingData) {
+		for _, meeting := range meetings {
+			if meeting.revealDue(now) {
+				due = true
+			}
+		}
+	})
+	if !due {
+		return nil
+	}
+	return store.Update(accountID, func(meetings map[string]*MeetingData) {
+		for meetingUUID, meeting := range meetings {
+			if !meeting.revealDue(now) {
+				continue
+			}
+			for i := range meeting.Draws {
+				if meeting.Draws[i].Number == meeting.RevealDraw {
+					broadcastRaffleResult(accountID, meetingUUID, meeting, &meeting.Draws[i])
+				}
+			}
+			// A draw dropped meanwhile has nothing left to reveal
+			meeting.RevealDraw = 0
+		}
+	})
+}
+
+// revealDue reports whether the winners of a draw are to be sent. The caller must hold the account mutex.
+func (m *MeetingData) revealDue(now time.Time) bool {
+	if m.RevealDraw == 0 {
+		return false
+	}
+	for _, d := range m.Draws {
+		if d.Number == m.RevealDraw {
+			return !d.RevealAt.After(now)
+		}
+	}
+	return true
+}
+
+// apiListDrawsHandler returns the draws of a meeting and the commitment of the next one. The seed
+// and winners of a draw are withheld until the viewers see them.
+func (h *handlers) apiListDrawsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	accountID, ok := apiAccount(w, r)
+	if !ok {
+		return
+	}
+
+	now := clock()
+	if err := revealDueDraws(h.store, accountID, now); err != nil {
+		log.Printf("Failed to reveal draws of account %s: %v", accountID, err)
+	}
+	var result *apiDraws
+	h.store.View(accountID, func(meetings map[string]*MeetingData) {
+		meetingUUID, meeting := selectMeeting(meetings, r.URL.Query().Get("meeting"))
+		if meeting == nil {
+			return
+		}
+		result = &apiDraws{Meeting: meetingUUID, Draws: make([]Draw, 0, len(meeting.Draws))}
+		for _, d := range meeting.Draws {
+			result.Draws = append(result.Draws, d.concealed(now))
+		}
+		if len(meeting.RaffleSeed) > 0 {
+			commitment := raffleCommitment(meeting.RaffleSeed)
+			result.Commitment = &commitment
+		}
+	})
+	if result == nil {
+		writeJSON(w, http.StatusNotFound, apiError{"Meeting nicht gefunden."})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// apiDrawHandler draws winners in a meeting. Without a meeting in the request, the most recently
+// updated one is used. Viewers are told to start the raffle animation right away and receive the
+// winners once it is over. The response withholds the winners until then as well.
+func (h *handlers) apiDrawHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	accountID, ok := apiAccount(w, r)
+	if !ok {
+		return
+	}
+
+	var req drawRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDrawBodySize)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"Ungültige Anfrage."})
+		return
+	}
+	if req.Count == 0 {
+		req.Count = 1
+	}
+	if req.Count < 0 || req.Count > maxDrawWinners {
+		writeJSON(w, http.StatusBadRequest, apiError{"Es können 1 bis " + strconv.Itoa(maxDrawWinners) + " Gewinner gezogen werden."})
+		return
+	}
+	if req.Duration == 0 {
+		req.Duration = int(defaultRaffleDuration / time.Second)
+	}
+	if req.Duration < 0 || time.Duration(req.Duration)*time.Second > maxRaffleDuration {
+		writeJSON(w, http.StatusBadRequest, apiError{"Die Ziehung dauert 1 bis " + strconv.Itoa(int(maxRaffleDuration/time.Second)) + " Sekunden."})
+		return
+	}
+	if len(req.ClientSeed) > maxClientSeedSize {
+		writeJSON(w, http.StatusBadRequest, apiError{"Der Client-Seed ist zu lang."})
+		return
+	}
+
+	var result *apiDrawResult
+	var drawErr error
+	err := h.store.Update(accountID, func(meetings map[string]*MeetingData) {
+		meetingUUID, meeting := selectMeeting(meetings, req.Meeting)
+		if meeting == nil || (req.Meeting != "" && meetingUUID != req.Meeting) {
+			return
+		}
+		var d *Draw
+		if d, drawErr = meeting.draw(req, clock()); drawErr == nil {
+			concealed := d.concealed(d.DrawnAt)
+			result = &apiDrawResult{Draw: &concealed, NextCommitment: raffleCommitment(meeting.RaffleSeed)}
+			broadcastRaffleStart(accountID, meetingUUID, meeting, d)
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to save draw of account %s: %v", accountID, err)
+		writeJSON(w, http.StatusInternalServerError, apiError{"Die Ziehung konnte nicht gespeichert werden."})
+		return
+	}
+	switch {
+	case errors.Is(drawErr, errRaffleRunning):
+		writeJSON(w, http.StatusConflict, apiError{"Es läuft bereits eine Ziehung."})
+	case errors.Is(drawErr, errCommitmentChanged):
+		writeJSON(w, http.StatusConflict, apiError{"Die Zusage für die nächste Ziehung hat sich geändert."})
+	case errors.Is(drawErr, errTooFewCandidates):
+		writeJSON(w, http.StatusUnprocessableEntity, apiError{"Nicht genügend Teilnehmer für die Ziehung."})
+	case result == nil:
+		writeJSON(w, http.StatusNotFound, apiError{"Meeting nicht gefunden."})
+	default:
+		revealAt := result.Draw.RevealAt
+		time.AfterFunc(time.Until(revealAt), func() {
+			if err := revealDueDraws(h.store, accountID, revealAt); err != nil {
+				log.Printf("Failed to reveal draw of account %s: %v", accountID, err)
+			}
+		})
+		writeJSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestDrawRevealedAfterAnimation(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	setClock(t, func() time.Time { return now })
+	addTestAccount(t, db, "acc", "viewerpassword123", defaultRetention)
+	store := newMemoryStore()
+	router := httprouter.New()
+	SetupHandlers(router, db, store)
+	h := &handlers{store: store}
+	ts := time.Now().UnixMilli()
+	for _, payload := range []ZoomWebhookPayload{
+		webhookPayload("acc", "meeting.participant_joined", "m1", "u1", "Alice", ts),
+		webhookPayload("acc", "meeting.participant_joined", "m1", "u2", "Bob", ts+1),
+	} {
+		if code := sendWebhook(t, h, payload); code != http.StatusOK {
+			t.Fatalf("webhook %s: got %d", payload.Event, code)
+		}
+	}
+
+	v := newViewer("sse", "m1", protocolV2)
+	subscribe(store, "acc", "", v)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/draws", strings.NewReader(`{"meeting": "m1", "duration": 30}`))
+	r.Header.Set("Authorization", "Bearer viewerpassword123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("draw: got %d: %s", w.Code, w.Body)
+	}
+	var drawn apiDrawResult
+	if err := json.Unmarshal(w.Body.Bytes(), &drawn); err != nil {
+		t.Fatal(err)
+	}
+	if drawn.Draw.Seed != "" || len(drawn.Draw.Winners) != 0 {
+		t.Errorf("draw response tells the outcome before the reveal: %+v", drawn.Draw)
+	}
+	if u := <-v.queue; !strings.Contains(string(u.v2), `"raffle_start"`) {
+		t.Errorf("first update: %s", u.v2)
+	}
+
+	validator := schemaValidator{parseYAML(t, "../../openapi.yaml")}
+	list := func() Draw {
+		t.Helper()
+		w := apiRequest(t, router, "/api/v1/draws?meeting=m1", "viewerpassword123")
+		var body any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("list: got %d, %v", w.Code, err)
+		}
+		for _, problem := range validator.validate(validator.resolve("#/components/schemas/Draws"), body, "draws") {
+			t.Error(problem)
+		}
+		var draws apiDraws
+		if err := json.Unmarshal(w.Body.Bytes(), &draws); err != nil || len(draws.Draws) != 1 {
+			t.Fatalf("list: got %s", w.Body)
+		}
+		return draws.Draws[0]
+	}
+
+	// While the raffle animation runs, the list must not tell the outcome
+	if d := list(); d.Seed != "" || len(d.Winners) != 0 || d.Commitment == "" || len(d.Candidates) != 2 {
+		t.Errorf("draw before reveal: %+v", d)
+	}
+
+	// Without the timer of the drawing instance, for example after a restart, the winners are sent
+	// once the animation is over, and only once
+	if err := revealDueDraws(store, "acc", now.Add(29*time.Second)); err != nil || len(v.queue) != 0 {
+		t.Fatalf("reveal during the animation: %v, %d updates", err, len(v.queue))
+	}
+	now = now.Add(30 * time.Second)
+	for range 2 {
+		if err := revealDueDraws(store, "acc", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(v.queue) != 1 {
+		t.Fatalf("reveal after the animation: got %d updates, want 1", len(v.queue))
+	}
+	if u := <-v.queue; !strings.Contains(string(u.v2), `"raffle_result"`) {
+		t.Errorf("reveal: %s", u.v2)
+	}
+	if d := list(); d.Seed == "" || len(d.Winners) != 1 {
+		t.Errorf("draw after reveal: %+v", d)
+	}
+}
@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// loginBackoffBase is the delay enforced after the first failed attempt; it doubles with every further failure
+	loginBackoffBase = time.Second
+	loginBackoffMax  = time.Minute
+	// loginLockoutThreshold failed attempts from one client lock it out for loginLockoutDuration
+	loginLockoutThreshold = 10
+	loginLockoutDuration  = 15 * time.Minute
+	// loginGlobalRate limits password checks per second across all clients, allowing bursts of loginGlobalBurst
+	loginGlobalRate  = 20
+	loginGlobalBurst = 40
+	// loginForgetAfter is how long a client's failures are remembered without further attempts
+	loginForgetAfter = time.Hour
+)
+
+var (
+	loginLimits = &loginLimiter{
+		clients: make(map[string]*loginAttempts),
+		tokens:  loginGlobalBurst,
+	}
+	// blockedLoginAttempts counts password attempts rejected by the rate limiter
+	blockedLoginAttempts atomic.Int64
+	// trustedProxies may set X-Forwarded-For. Connections over a Unix socket are always trusted.
+	trustedProxies []*net.IPNet
+)
+
+// loginAttempts tracks the failed password attempts of one client
+type loginAttempts struct {
+	failures    int
+	nextAttempt time.Time
+	lastSeen    time.Time
+}
+
+// loginLimiter applies per-client exponential backoff and lockout plus a global token bucket
+type loginLimiter struct {
+	sync.Mutex
+	clients    map[string]*loginAttempts // Key: Client IP
+	tokens     float64
+	lastRefill time.Time
+	lastPrune  time.Time
+}
+
+// parseTrustedProxy parses a trusted proxy given as IP address or CIDR range
+func parseTrustedProxy(entry string) (*net.IPNet, error) {
+	cidr := entry
+	if !strings.Contains(cidr, "/") {
+		if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+			cidr += "/32"
+		} else {
+			cidr += "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+	}
+	return network, nil
+}
+
+// isTrustedProxy reports whether the given peer address may set X-Forwarded-For
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP determines the address of the client. X-Forwarded-For is only honoured if the request
+// came from a trusted proxy, and is read from the right, skipping further trusted proxies.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	// Requests over a Unix socket have no peer IP and can only come from a local reverse proxy
+	if peer != nil && !isTrustedProxy(peer) {
+		return peer.String()
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+		if !isTrustedProxy(ip) || i == 0 {
+			return ip.String()
+		}
+	}
+	if peer != nil {
+		return peer.String()
+	}
+	return host
+}
+
+// allow reports whether a password attempt from the client may be checked now
+func (l *loginLimiter) allow(ip string, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.delayed(ip, now) {
+		return false
+	}
+	l.refill(now)
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// admit reports whether a client may try a password without spending a global token. With global
+// set, the global limit must not be exhausted either. Failures are charged afterwards with penalize.
+func (l *loginLimiter) admit(ip string, now time.Time, global bool) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.delayed(ip, now) {
+		return false
+	}
+	if !global {
+		return true
+	}
+	l.refill(now)
+	return l.tokens >= 1
+}
+
+// penalize records a wrong password that admit let through, spending a global token for it
+func (l *loginLimiter) penalize(ip string, now time.Time) {
+	l.Lock()
+	l.refill(now)
+	l.tokens = math.Max(0, l.tokens-1)
+	l.Unlock()
+	l.failure(ip, now)
+}
+
+// delayed reports whether the client has to wait after failed attempts. The caller must hold the lock.
+func (l *loginLimiter) delayed(ip string, now time.Time) bool {
+	l.prune(now)
+	if attempts, exists := l.clients[ip]; exists {
+		attempts.lastSeen = now
+		return now.Before(attempts.nextAttempt)
+	}
+	return false
+}
+
+// refill adds the global tokens earned since the last refill. The caller must hold the lock.
+func (l *loginLimiter) refill(now time.Time) {
+	// Requests read the clock before waiting for the lock, so now may lie before the last refill
+	if now.Before(l.lastRefill) {
+		return
+	}
+	if !l.lastRefill.IsZero() {
+		l.tokens = math.Min(loginGlobalBurst, l.tokens+now.Sub(l.lastRefill).Seconds()*loginGlobalRate)
+	}
+	l.lastRefill = now
+}
+
+// failure records a wrong password and delays the client's next attempt
+func (l *loginLimiter) failure(ip string, now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	attempts, exists := l.clients[ip]
+	if !exists {
+		attempts = &loginAttempts{}
+		l.clients[ip] = attempts
+	}
+	attempts.failures++
+	attempts.lastSeen = now
+	if attempts.failures >= loginLockoutThreshold {
+		attempts.nextAttempt = now.Add(loginLockoutDuration)
+		log.Printf("Locked out %s after %d failed password attempts", ip, attempts.failures)
+		return
+	}
+	backoff := loginBackoffBase << (attempts.failures - 1)
+	if backoff > loginBackoffMax {
+		backoff = loginBackoffMax
+	}
+	attempts.nextAttempt = now.Add(backoff)
+}
+
+// success forgets the failures of a client after a correct password
+func (l *loginLimiter) success(ip string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.clients, ip)
+}
+
+// prune forgets clients without recent attempts whose lockout has ended. The caller must hold the lock.
+func (l *loginLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	for ip, attempts := range l.clients {
+		if now.Sub(attempts.lastSeen) > loginForgetAfter && now.After(attempts.nextAttempt) {
+			delete(l.clients, ip)
+		}
+	}
+	l.lastPrune = now
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		delay    time.Duration // How long the client has to wait after the last failure
+	}{
+		{1, loginBackoffBase},
+		{2, 2 * loginBackoffBase},
+		{3, 4 * loginBackoffBase},
+		{6, 32 * loginBackoffBase},
+		{7, loginBackoffMax},
+		{loginLockoutThreshold - 1, loginBackoffMax},
+		{loginLockoutThreshold, loginLockoutDuration},
+		{loginLockoutThreshold + 5, loginLockoutDuration},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.failures)+" failures", func(t *testing.T) {
+			resetState(nil)
+			now := time.Unix(1_700_000_000, 0)
+			for range tt.failures {
+				loginLimits.failure("192.0.2.1", now)
+			}
+			if loginLimits.allow("192.0.2.1", now.Add(tt.delay-time.Millisecond)) {
+				t.Errorf("%d failures: allowed before %v", tt.failures, tt.delay)
+			}
+			if !loginLimits.allow("192.0.2.1", now.Add(tt.delay)) {
+				t.Errorf("%d failures: still blocked after %v", tt.failures, tt.delay)
+			}
+			// Other clients are not affected
+			if !loginLimits.allow("192.0.2.2", now) {
+				t.Errorf("%d failures: other client blocked", tt.failures)
+			}
+		})
+	}
+}
+
+func TestLockoutExpiry(t *testing.T) {
+	resetState(nil)
+	now := time.Unix(1_700_000_000, 0)
+	for range loginLockoutThreshold {
+		loginLimits.failure("192.0.2.1", now)
+	}
+
+	// After the lockout and a long pause the failures are forgotten, so the next one only delays
+	// the client by the base delay
+	now = now.Add(loginLockoutDuration + loginForgetAfter + time.Minute)
+	if !loginLimits.allow("192.0.2.2", now) {
+		t.Fatal("other client blocked")
+	}
+	loginLimits.failure("192.0.2.1", now)
+	if !loginLimits.allow("192.0.2.1", now.Add(loginBackoffBase)) {
+		t.Error("failures before the lockout were not forgotten")
+	}
+}
+
+func TestBlockedLoginAttempts(t *testing.T) {
+	db := newTestDB(t)
+	addTestAccount(t, db, "acc", "viewerpassword123", defaultRetention)
+	now := time.Unix(1_700_000_000, 0)
+	setClock(t, func() time.Time { return now })
+	previous := trustedProxies
+	t.Cleanup(func() { trustedProxies = previous })
+	trustedProxies = []*net.IPNet{{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(8, 32)}}
+
+	login := func(password, forwardedFor string) string {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		_, errorMessage := authenticateViewer(r, password)
+		return errorMessage
+	}
+	if message := login("wrongpassword", "203.0.113.1"); message != "Falsches Passwort." {
+		t.Fatalf("wrong password: %s", message)
+	}
+
+	// A client that is not a trusted proxy cannot escape the delay by claiming another address
+	blocked := blockedLoginAttempts.Load()
+	if message := login("viewerpassword123", "203.0.113.2"); message == "" {
+		t.Fatal("attempt during the delay was checked")
+	}
+	if count := blockedLoginAttempts.Load() - blocked; count != 1 {
+		t.Errorf("blocked attempts counted: %d, want 1", count)
+	}
+
+	now = now.Add(loginBackoffBase)
+	if message := login("viewerpassword123", "203.0.113.2"); message != "" {
+		t.Errorf("attempt after the delay: %s", message)
+	}
+	if count := blockedLoginAttempts.Load() - blocked; count != 1 {
+		t.Errorf("blocked attempts counted: %d, want 1", count)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	previous := trustedProxies
+	t.Cleanup(func() { trustedProxies = previous })
+	for _, entry := range []string{"10.0.0.0/8", "2001:db8::1"} {
+		network, err := parseTrustedProxy(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		want         string
+	}{
+		{"direct", "192.0.2.1:1234", "", "192.0.2.1"},
+		{"spoofed by untrusted peer", "192.0.2.1:1234", "203.0.113.1", "192.0.2.1"},
+		{"trusted proxy", "10.0.0.1:1234", "203.0.113.1", "203.0.113.1"},
+		{"trusted IPv6 proxy", "[2001:db8::1]:1234", "203.0.113.1", "203.0.113.1"},
+		{"spoofed through trusted proxy", "10.0.0.1:1234", "198.51.100.1, 203.0.113.1", "203.0.113.1"},
+		{"chain of trusted proxies", "10.0.0.1:1234", "203.0.113.1, 10.0.0.2", "203.0.113.1"},
+		{"only trusted proxies", "10.0.0.1:1234", "10.0.0.2", "10.0.0.2"},
+		{"invalid header", "10.0.0.1:1234", "unknown", "10.0.0.1"},
+		{"trusted proxy without header", "10.0.0.1:1234", "", "10.0.0.1"},
+		{"unix socket", "@", "203.0.113.1", "203.0.113.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
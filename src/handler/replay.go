@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// webhookMaxAge is how old a webhook timestamp may be before the request is rejected, see Config
+	webhookMaxAge = 5 * time.Minute
+	// webhookClockSkew is how far a webhook timestamp may lie in the future
+	webhookClockSkew = 30 * time.Second
+	// clock returns the current time and can be replaced in tests
+	clock = time.Now
+
+	seenWebhooks = &replayCache{entries: make(map[string]time.Time)}
+
+	errWebhookTimestamp = errors.New("invalid webhook timestamp")
+	errWebhookExpired   = errors.New("webhook timestamp outside of acceptance window")
+	errWebhookReplayed  = errors.New("webhook already processed")
+)
+
+// replayCache remembers the signatures of accepted webhooks until they fall out of the acceptance
+// window. With a database, the signatures are kept there, so instances sharing it see each other's.
+type replayCache struct {
+	sync.Mutex
+	entries   map[string]time.Time // Key: Signature, Value: time after which the entry may be forgotten
+	db        *sql.DB
+	lastPrune time.Time
+}
+
+// checkWebhookTimestamp rejects webhooks whose x-zm-request-timestamp is outside of the acceptance
+// window. It returns when the signature may be forgotten, see reserveWebhook.
+func checkWebhookTimestamp(timestamp string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, errWebhookTimestamp
+	}
+	sent := time.Unix(seconds, 0)
+	now := clock()
+	if now.Sub(sent) > webhookMaxAge || sent.Sub(now) > webhookClockSkew {
+		return time.Time{}, errWebhookExpired
+	}
+	return sent.Add(webhookMaxAge + webhookClockSkew), nil
+}
+
+// reserveWebhook records the signature of a webhook before it is applied and reports
+// errWebhookReplayed if it was recorded already. It must only be called for requests with a valid
+// signature. If applying the webhook fails, releaseWebhook lets Zoom retry it.
+func reserveWebhook(signature string, expires time.Time) error {
+	reserved, err := seenWebhooks.reserve(signature, expires, clock())
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		return errWebhookReplayed
+	}
+	return nil
+}
+
+// releaseWebhook forgets the signature of a webhook that could not be applied
+func releaseWebhook(signature string) {
+	if err := seenWebhooks.release(signature); err != nil {
+		log.Printf("Failed to release webhook signature: %v", err)
+	}
+}
+
+// reserve records a signature until the given expiry unless it is already known and not expired.
+// It reports whether the signature was recorded, and forgets expired ones.
+func (c *replayCache) reserve(signature string, expires, now time.Time) (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	prune := now.Sub(c.lastPrune) > time.Minute
+	if prune {
+		c.lastPrune = now
+	}
+	if c.db != nil {
+		return c.reserveShared(signature, expires, now, prune)
+	}
+	if prune {
+		for key, expiry := range c.entries {
+			if now.After(expiry) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	if expiry, exists := c.entries[signature]; exists && !now.After(expiry) {
+		return false, nil
+	}
+	c.entries[signature] = expires
+	return true, nil
+}
+
+// reserveShared records a signature in the database. The insert only succeeds for unknown or
+// expired signatures, which makes the check atomic across instances.
+func (c *replayCache) reserveShared(signature string, expires, now time.Time, prune bool) (bool, error) {
+	if prune {
+		if _, err := c.db.Exec("DELETE FROM webhook_signatures WHERE expires_at < ?", now.Unix()); err != nil {
+			return false, err
+		}
+	}
+	result, err := c.db.Exec(`INSERT INTO webhook_signatures (signature, expires_at) VALUES (?, ?)
+		ON CONFLICT (signature) DO UPDATE SET expires_at = excluded.expires_at WHERE webhook_signatures.expires_at < ?`,
+		signature, expires.Unix(), now.Unix())
+	if err != nil {
+		return false, err
+	}
+	recorded, err := result.RowsAffected()
+	return recorded > 0, err
+}
+
+// release forgets a signature
+func (c *replayCache) release(signature string) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.db != nil {
+		_, err := c.db.Exec("DELETE FROM webhook_signatures WHERE signature = ?", signature)
+		return err
+	}
+	delete(c.entries, signature)
+	return nil
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckWebhookTimestamp(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	setClock(t, func() time.Time { return now })
+
+	tests := []struct {
+		name string
+		sent time.Time
+		want error
+	}{
+		{"current", now, nil},
+		{"oldest accepted", now.Add(-webhookMaxAge), nil},
+		{"too old", now.Add(-webhookMaxAge - time.Second), errWebhookExpired},
+		{"within skew", now.Add(webhookClockSkew), nil},
+		{"beyond skew", now.Add(webhookClockSkew + time.Second), errWebhookExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := checkWebhookTimestamp(strconv.FormatInt(tt.sent.Unix(), 10))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := checkWebhookTimestamp("yesterday"); !errors.Is(err, errWebhookTimestamp) {
+		t.Errorf("invalid timestamp: got %v", err)
+	}
+}
+
+func TestReserveWebhook(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	setClock(t, func() time.Time { return now })
+	expires := now.Add(webhookMaxAge)
+
+	for _, shared := range []bool{false, true} {
+		t.Run(map[bool]string{false: "memory", true: "database"}[shared], func(t *testing.T) {
+			db := newTestDB(t)
+			if shared {
+				seenWebhooks.db = db
+			}
+			if err := reserveWebhook("v0=abc", expires); err != nil {
+				t.Fatal(err)
+			}
+			if err := reserveWebhook("v0=abc", expires); !errors.Is(err, errWebhookReplayed) {
+				t.Fatalf("reserved webhook: got %v, want %v", err, errWebhookReplayed)
+			}
+			if err := reserveWebhook("v0=other", expires); err != nil {
+				t.Fatalf("other signature: got %v", err)
+			}
+			if shared {
+				// Another instance sharing the database knows the signature as well
+				other := &replayCache{entries: make(map[string]time.Time), db: db}
+				if reserved, err := other.reserve("v0=abc", expires, now); err != nil || reserved {
+					t.Fatalf("other instance: reserved %v, %v", reserved, err)
+				}
+			}
+			// A webhook that could not be applied may be delivered again
+			releaseWebhook("v0=abc")
+			if err := reserveWebhook("v0=abc", expires); err != nil {
+				t.Fatalf("released webhook: got %v", err)
+			}
+		})
+	}
+}
+
+func TestReplayCachePrune(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	setClock(t, func() time.Time { return now })
+	seenWebhooks = &replayCache{entries: make(map[string]time.Time)}
+
+	expires, err := checkWebhookTimestamp(strconv.FormatInt(now.Unix(), 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reserveWebhook("v0=old", expires); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the timestamp left the acceptance window, the signature is forgotten with the next webhook
+	now = expires.Add(time.Minute)
+	expires, err = checkWebhookTimestamp(strconv.FormatInt(now.Unix(), 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reserveWebhook("v0=new", expires); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := seenWebhooks.entries["v0=old"]; exists {
+		t.Error("expired signature was not pruned")
+	}
+	if _, exists := seenWebhooks.entries["v0=new"]; !exists {
+		t.Error("new signature was not remembered")
+	}
+}
+
+func TestConcurrentWebhookDeliveries(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		t.Run(map[bool]string{false: "memory", true: "database"}[shared], func(t *testing.T) {
+			db := newTestDB(t)
+			if shared {
+				seenWebhooks.db = db
+			}
+			addTestAccount(t, db, "acc", "viewerpassword123", defaultRetention)
+			store := newMemoryStore()
+			h := &handlers{store: store}
+
+			// Zoom delivers the same signed request several times at once
+			payload := webhookPayload("acc", "meeting.participant_joined", "m1", "u1", "Alice", time.Now().UnixMilli())
+			requests := make([]*http.Request, 8)
+			for i := range requests {
+				requests[i] = signedWebhook(t, payload, clock())
+			}
+			var wg sync.WaitGroup
+			for i, r := range requests {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					w := httptest.NewRecorder()
+					h.webhookHandler(w, r, nil)
+					if w.Code != http.StatusOK {
+						t.Errorf("delivery %d: got %d", i, w.Code)
+					}
+				}()
+			}
+			wg.Wait()
+
+			store.View("acc", func(meetings map[string]*MeetingData) {
+				participant := meetings["m1"].Participants["u1"]
+				if participant == nil || len(participant.Sessions) != 1 {
+					t.Errorf("participant sessions: got %+v", participant)
+				}
+			})
+		})
+	}
+}
+
+// flakyStore fails the first update, like a database that is briefly unavailable
+type flakyStore struct {
+	Store
+	failures int
+}
+
+func (s *flakyStore) Update(accountID string, fn func(meetings map[string]*MeetingData)) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("database is locked")
+	}
+	return s.Store.Update(accountID, fn)
+}
+
+func TestWebhookRetryAfterFailure(t *testing.T) {
+	db := newTestDB(t)
+	addTestAccount(t, db, "acc", "viewerpassword123", defaultRetention)
+	store := &flakyStore{Store: newMemoryStore(), failures: 1}
+	h := &handlers{store: store}
+
+	payload := webhookPayload("acc", "meeting.participant_joined", "m1", "u1", "Alice", time.Now().UnixMilli())
+	request := func() int {
+		w := httptest.NewRecorder()
+		h.webhookHandler(w, signedWebhook(t, payload, clock()), nil)
+		return w.Code
+	}
+	if code := request(); code != http.StatusInternalServerError {
+		t.Fatalf("failing update: got %d", code)
+	}
+	// Zoom retries the same signed request, it must be applied now
+	if code := request(); code != http.StatusOK {
+		t.Fatalf("retry: got %d", code)
+	}
+	// A further delivery is confirmed without applying it twice
+	if code := request(); code != http.StatusOK {
+		t.Fatalf("duplicate: got %d", code)
+	}
+	store.View("acc", func(meetings map[string]*MeetingData) {
+		participant := meetings["m1"].Participants["u1"]
+		if participant == nil || len(participant.Sessions) != 1 {
+			t.Errorf("participant sessions: got %+v", participant)
+		}
+	})
+}
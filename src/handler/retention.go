@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Retention modes of an account
+const (
+	retentionMax      = "max"       // Keep meetings until they had no updates for the server's meeting retention
+	retentionOnEnd    = "on_end"    // Remove a meeting as soon as it ends
+	retentionAfterEnd = "after_end" // Keep an ended meeting for a number of minutes
+)
+
+// RetentionPolicy controls how long the participant data of an account is kept
+type RetentionPolicy struct {
+	Mode       string
+	Minutes    int  // Minutes an ended meeting is kept in retentionAfterEnd mode
+	MemoryOnly bool // Participant names are never written to disk, not even in encrypted form
+}
+
+// defaultRetention applies to accounts that never changed their settings
+var defaultRetention = RetentionPolicy{Mode: retentionMax}
+
+// validate checks the policy against the server's maximum retention and returns an error message for the viewer
+func (p RetentionPolicy) validate() string {
+	switch p.Mode {
+	case retentionMax, retentionOnEnd:
+		return ""
+	case retentionAfterEnd:
+		if p.Minutes < 1 || time.Duration(p.Minutes)*time.Minute > meetingRetention {
+			return fmt.Sprintf("Die Aufbewahrungsdauer muss zwischen 1 und %d Minuten liegen.", int(meetingRetention.Minutes()))
+		}
+		return ""
+	}
+	return "Unbekannte Aufbewahrungsregel."
+}
+
+// expired reports whether a meeting must be removed. The server's maximum retention applies to all
+// policies. The caller must hold the account mutex.
+func (p RetentionPolicy) expired(meeting *MeetingData, now time.Time) bool {
+	if now.Sub(meeting.LastUpdated) > meetingRetention {
+		return true
+	}
+	if !meeting.Ended {
+		return false
+	}
+	switch p.Mode {
+	case retentionOnEnd:
+		return true
+	case retentionAfterEnd:
+		return now.Sub(meeting.EndedAt) > time.Duration(p.Minutes)*time.Minute
+	}
+	return false
+}
+
+// Describe explains the policy to viewers
+func (p RetentionPolicy) Describe() string {
+	var description string
+	switch p.Mode {
+	case retentionOnEnd:
+		description = "Teilnehmerdaten werden gelöscht, sobald das Meeting endet."
+	case retentionAfterEnd:
+		description = fmt.Sprintf("Teilnehmerdaten werden %d Minuten nach Ende des Meetings gelöscht.", p.Minutes)
+	default:
+		description = fmt.Sprintf("Teilnehmerdaten werden %s nach der letzten Änderung gelöscht.", formatRetention(meetingRetention))
+	}
+	if p.MemoryOnly {
+		description += " Namen werden ausschließlich im Arbeitsspeicher gehalten."
+	}
+	return description
+}
+
+// formatRetention writes a duration in hours or minutes for the viewer page
+func formatRetention(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if d == time.Hour {
+			return "1 Stunde"
+		}
+		return fmt.Sprintf("%d Stunden", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d Minuten", int(d.Minutes()))
+}
+
+// loadRetention reads the policy of an account from the database
+func loadRetention(accountID string) (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	err := appState.DB.QueryRow("SELECT retention_mode, retention_minutes, memory_only FROM accounts WHERE account_id = ?", accountID).
+		Scan(&policy.Mode, &policy.Minutes, &policy.MemoryOnly)
+	return policy, err
+}
+
+// retentionFor returns the cached policy of an account, loading it if necessary
+func retentionFor(accountID string) RetentionPolicy {
+	appState.PasswordMutex.RLock()
+	policy, exists := appState.Retention[accountID]
+	appState.PasswordMutex.RUnlock()
+	if exists {
+		return policy
+	}
+
+	ensureAccountInitialized(accountID)
+	appState.PasswordMutex.RLock()
+	defer appState.PasswordMutex.RUnlock()
+	if policy, exists := appState.Retention[accountID]; exists {
+		return policy
+	}
+	return defaultRetention
+}
+
+// updateRetentionHandler changes the retention policy of an account. The current viewer password
+// must be entered again.
+func (h *handlers) updateRetentionHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	accountID, errorMessage := authenticateViewer(r, r.FormValue("current_password"))
+	if accountID == "" {
+		renderError(w, errorMessage)
+		return
+	}
+
+	policy := RetentionPolicy{
+		Mode:       r.FormValue("retention_mode"),
+		MemoryOnly: r.FormValue("memory_only") == "on",
+	}
+	if policy.Mode == retentionAfterEnd {
+		policy.Minutes, _ = strconv.Atoi(r.FormValue("retention_minutes"))
+	}
+	if errorMessage := policy.validate(); errorMessage != "" {
+		renderError(w, errorMessage)
+		return
+	}
+
+	_, err := appState.DB.Exec("UPDATE accounts SET retention_mode = ?, retention_minutes = ?, memory_only = ? WHERE account_id = ?",
+		policy.Mode, policy.Minutes, policy.MemoryOnly, accountID)
+	if err != nil {
+		renderError(w, fmt.Sprintf("Fehler beim Aktualisieren des Kontos: %v", err))
+		return
+	}
+	bus.AccountChanged(accountID, false)
+
+	// Meetings that are already past the new retention are removed right away
+	if err := purgeExpiredMeetings(h.store, accountID, clock()); err != nil {
+		log.Printf("Failed to remove expired meetings of account %s: %v", accountID, err)
+	}
+	rewriteSnapshot(h.store)
+	log.Printf("Updated retention of account: %s", accountID)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// purgeExpiredMeetings removes the meetings of an account that its retention policy no longer allows to keep
+func purgeExpiredMeetings(store Store, accountID string, now time.Time) error {
+	policy := retentionFor(accountID)
+	return store.Update(accountID, func(meetings map[string]*MeetingData) {
+		for uuid, meeting := range meetings {
+			if policy.expired(meeting, now) {
+				delete(meetings, uuid)
+				log.Printf("Cleaned up old meeting: %s for account: %s", uuid, accountID)
+			}
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionPurge(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		kept   []time.Duration // Times after the end at which the meeting is still kept
+		purged time.Duration   // First time after the end at which it is removed
+	}{
+		{"on end", RetentionPolicy{Mode: retentionOnEnd}, nil, 0},
+		{"after end", RetentionPolicy{Mode: retentionAfterEnd, Minutes: 10}, []time.Duration{0, 10 * time.Minute}, 10*time.Minute + time.Second},
+		{"max", defaultRetention, []time.Duration{0, meetingRetention}, meetingRetention + time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			addTestAccount(t, db, "acc", "viewerpassword123", tt.policy)
+			store := newMemoryStore()
+			h := &handlers{store: store}
+
+			ts := time.Now().UnixMilli()
+			for _, payload := range []ZoomWebhookPayload{
+				webhookPayload("acc", "meeting.participant_joined", "m1", "u1", "Alice", ts),
+				webhookPayload("acc", "meeting.ended", "m1", "", "", ts+1),
+			} {
+				if code := sendWebhook(t, h, payload); code != http.StatusOK {
+					t.Fatalf("webhook %s: got %d", payload.Event, code)
+				}
+			}
+
+			var endedAt time.Time
+			store.View("acc", func(meetings map[string]*MeetingData) {
+				if meeting := meetings["m1"]; meeting != nil {
+					endedAt = meeting.EndedAt
+				}
+			})
+			if tt.kept == nil {
+				// The meeting is removed with the end event already
+				if !endedAt.IsZero() {
+					t.Fatal("ended meeting was kept")
+				}
+				return
+			}
+			if endedAt.IsZero() {
+				t.Fatal("ended meeting was removed right away")
+			}
+
+			exists := func(offset time.Duration) bool {
+				if err := purgeExpiredMeetings(store, "acc", endedAt.Add(offset)); err != nil {
+					t.Fatal(err)
+				}
+				found := false
+				store.View("acc", func(meetings map[string]*MeetingData) {
+					_, found = meetings["m1"]
+				})
+				return found
+			}
+			for _, offset := range tt.kept {
+				if !exists(offset) {
+					t.Fatalf("removed %v after the end", offset)
+				}
+			}
+			if exists(tt.purged) {
+				t.Fatalf("kept %v after the end", tt.purged)
+			}
+		})
+	}
+}
+
+func TestMemoryOnlyNeverWritten(t *testing.T) {
+	db := newTestDB(t)
+	addTestAccount(t, db, "mem", "memorypassword123", RetentionPolicy{Mode: retentionMax, MemoryOnly: true})
+	addTestAccount(t, db, "disk", "diskpassword123", defaultRetention)
+	store, err := newSQLiteStore(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := bus
+	bus = &sqliteBus{db: db, instance: "test"}
+	t.Cleanup(func() { bus = previous })
+	previousFile := snapshotFile
+	snapshotFile = filepath.Join(t.TempDir(), "snapshot.bin")
+	t.Cleanup(func() { snapshotFile = previousFile })
+
+	h := &handlers{store: store}
+	ts := time.Now().UnixMilli()
+	for _, accountID := range []string{"mem", "disk"} {
+		for _, payload := range []ZoomWebhookPayload{
+			webhookPayload(accountID, "meeting.participant_joined", "m1", "u1", "Alice", ts),
+			webhookPayload(accountID, "meeting.participant_joined_waiting_room", "m1", "u2", "Bob", ts+1),
+		} {
+			if code := sendWebhook(t, h, payload); code != http.StatusOK {
+				t.Fatalf("webhook %s of %s: got %d", payload.Event, accountID, code)
+			}
+		}
+	}
+
+	rows := func(table, accountID string) int {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE account_id = ?", accountID).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	if count := rows("meetings", "mem"); count != 0 {
+		t.Errorf("memory-only account has %d meetings in the database", count)
+	}
+	if count := rows("meetings", "disk"); count != 1 {
+		t.Errorf("other account has %d meetings in the database, want 1", count)
+	}
+	if count := rows("bus_messages", "mem"); count != 0 {
+		t.Errorf("memory-only account has %d bus messages", count)
+	}
+	if count := rows("bus_messages", "disk"); count != 2 {
+		t.Errorf("other account has %d bus messages, want 2", count)
+	}
+
+	if err := SaveSnapshot(store); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := os.ReadFile(snapshotFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := openSnapshot(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state snapshotContent
+	if err := json.Unmarshal(plain, &state); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := state.Accounts["mem"]; exists {
+		t.Error("memory-only account is in the snapshot")
+	}
+	if _, exists := state.Accounts["disk"]; !exists {
+		t.Error("other account is missing in the snapshot")
+	}
+
+	// Switching to memory only deletes the meetings written before with the next change
+	if _, err := db.Exec("UPDATE accounts SET memory_only = 1 WHERE account_id = ?", "disk"); err != nil {
+		t.Fatal(err)
+	}
+	accountChanged("disk", false)
+	if code := sendWebhook(t, h, webhookPayload("disk", "meeting.participant_left", "m1", "u1", "Alice", ts+2)); code != http.StatusOK {
+		t.Fatalf("webhook after switching: got %d", code)
+	}
+	if count := rows("meetings", "disk"); count != 0 {
+		t.Errorf("account switched to memory only still has %d meetings in the database", count)
+	}
+	if count := rows("bus_messages", "disk"); count != 2 {
+		t.Errorf("account switched to memory only has %d bus messages, want 2", count)
+	}
+}
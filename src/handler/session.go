@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	sessionCookieName = "zoom_session"
+	sessionTTL        = 12 * time.Hour
+)
+
+// sessionKey signs session cookies and is derived from the server key
+var sessionKey []byte
+
+// sessionSignature binds a session to the account's current viewer password, so that changing
+// the password ends all sessions. The nonce tells apart sessions issued at the same time, so that
+// logging out of one leaves the others intact.
+func sessionSignature(accountID string, expires int64, nonce, lookup string) string {
+	h := hmac.New(sha256.New, sessionKey)
+	fmt.Fprintf(h, "%s|%d|%s|%s", accountID, expires, nonce, lookup)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// issueSession sets a signed session cookie for the account after a successful login
+func issueSession(w http.ResponseWriter, r *http.Request, accountID string) error {
+	var lookup string
+	err := appState.DB.QueryRow("SELECT viewer_lookup FROM accounts WHERE account_id = ?", accountID).Scan(&lookup)
+	if err != nil {
+		return err
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(random)
+	expires := time.Now().Add(sessionTTL)
+	value := strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString([]byte(accountID)),
+		strconv.FormatInt(expires.Unix(), 10),
+		nonce,
+		sessionSignature(accountID, expires.Unix(), nonce, lookup),
+	}, ".")
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// sessionAccount returns the account of a valid session cookie
+func sessionAccount(r *http.Request) (string, bool) {
+	accountID, _, _, valid := verifySession(r)
+	return accountID, valid
+}
+
+// verifySession checks the session cookie and returns its account, expiry and signature. Sessions
+// that were logged out or belong to an old viewer password are invalid.
+func verifySession(r *http.Request) (accountID string, expires int64, signature string, valid bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return "", 0, "", false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 4 {
+		return "", 0, "", false
+	}
+	rawAccountID, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", 0, "", false
+	}
+	expires, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", 0, "", false
+	}
+
+	accountID = string(rawAccountID)
+	var lookup string
+	var revoked bool
+	err = appState.DB.QueryRow("SELECT viewer_lookup, EXISTS (SELECT 1 FROM revoked_sessions WHERE signature = ?) FROM accounts WHERE account_id = ? AND status = ?",
+		parts[3], accountID, accountActive).Scan(&lookup, &revoked)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to verify session: %v", err)
+		}
+		return "", 0, "", false
+	}
+	if revoked || !hmac.Equal([]byte(parts[3]), []byte(sessionSignature(accountID, expires, parts[2], lookup))) {
+		return "", 0, "", false
+	}
+	return accountID, expires, parts[3], true
+}
+
+// revokeSession ends a session on the server, so that a copy of the cookie is rejected as well. The
+// signature is kept until the session would have expired, older ones are removed.
+func revokeSession(expires int64, signature string) error {
+	if _, err := appState.DB.Exec("DELETE FROM revoked_sessions WHERE expires_at < ?", time.Now().Unix()); err != nil {
+		return err
+	}
+	_, err := appState.DB.Exec("INSERT OR IGNORE INTO revoked_sessions (signature, expires_at) VALUES (?, ?)", signature, expires)
+	return err
+}
+
+// clearSession removes the session cookie
+func clearSession(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// isHTTPS reports whether the client reached us over HTTPS, directly or through the reverse proxy
+func isHTTPS(r *http.Request) bool {
+	return publicHTTPS || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// logoutHandler ends the viewer session
+func logoutHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if accountID, expires, signature, valid := verifySession(r); valid {
+		if err := revokeSession(expires, signature); err != nil {
+			log.Printf("Failed to revoke session of account %s: %v", accountID, err)
+		}
+	}
+	clearSession(w, r)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionRevocation(t *testing.T) {
+	db := newTestDB(t)
+	addTestAccount(t, db, "acc", "viewerpassword123", defaultRetention)
+
+	login := func() *http.Cookie {
+		t.Helper()
+		w := httptest.NewRecorder()
+		if err := issueSession(w, httptest.NewRequest(http.MethodPost, "/", nil), "acc"); err != nil {
+			t.Fatal(err)
+		}
+		return w.Result().Cookies()[0]
+	}
+	valid := func(cookie *http.Cookie) bool {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(cookie)
+		_, authenticated := sessionAccount(r)
+		return authenticated
+	}
+	first, second := login(), login()
+	copied := *first
+	if !valid(first) || !valid(&copied) || !valid(second) {
+		t.Fatal("new session is not valid")
+	}
+
+	// Logging out ends the session on the server, so a copy of the cookie no longer works either
+	r := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	r.AddCookie(first)
+	logoutHandler(httptest.NewRecorder(), r, nil)
+	if valid(&copied) {
+		t.Error("copy of a logged out session is still valid")
+	}
+	if !valid(second) {
+		t.Error("logging out ended another session")
+	}
+
+	// Changing the viewer password ends all other sessions
+	if _, err := db.Exec("UPDATE accounts SET viewer_lookup = ? WHERE account_id = ?", viewerLookup("newpassword12345"), "acc"); err != nil {
+		t.Fatal(err)
+	}
+	if valid(second) {
+		t.Error("session is valid after the password changed")
+	}
+	if !valid(login()) {
+		t.Error("session after the password change is not valid")
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testSecret signs the webhooks of test accounts
+const testSecret = "secretsecretsecret1"
+
+// newTestDB loads a fixed server key, opens a migrated database in a temporary directory and
+// resets the global state shared by the handlers
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	if err := setServerKey(bytes.Repeat([]byte{7}, serverKeySize)); err != nil {
+		t.Fatal(err)
+	}
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	resetState(db)
+	return db
+}
+
+// resetState forgets cached accounts, seen webhooks, failed logins and viewers of earlier tests
+func resetState(db *sql.DB) {
+	appState.PasswordMutex.Lock()
+	appState.PasswordToAccountID = make(map[string]string)
+	appState.Retention = make(map[string]RetentionPolicy)
+	appState.DB = db
+	appState.PasswordMutex.Unlock()
+
+	seenWebhooks = &replayCache{entries: make(map[string]time.Time)}
+	loginLimits = &loginLimiter{clients: make(map[string]*loginAttempts), tokens: loginGlobalBurst}
+	bus = localBus{}
+	viewers.Lock()
+	viewers.byAccount = make(map[string]map[*viewer]struct{})
+	viewers.Unlock()
+}
+
+// setClock replaces the clock for the duration of a test
+func setClock(t *testing.T, now func() time.Time) {
+	t.Helper()
+	previous := clock
+	clock = now
+	t.Cleanup(func() { clock = previous })
+}
+
+// addTestAccount registers an active account signing its webhooks with testSecret
+func addTestAccount(t *testing.T, db *sql.DB, accountID, viewerPassword string, policy RetentionPolicy) {
+	t.Helper()
+	encrypted, err := encryptSecret(accountID, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := hashViewerPassword(viewerPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO accounts (account_id, secret_token_enc, viewer_lookup, viewer_hash, status, created_at, retention_mode, retention_minutes, memory_only) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		accountID, encrypted, viewerLookup(viewerPassword), hash, accountActive, time.Now().Unix(), policy.Mode, policy.Minutes, policy.MemoryOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// webhookPayload builds a participant event of a meeting
+func webhookPayload(accountID, event, meetingUUID, userID, userName string, eventTS int64) ZoomWebhookPayload {
+	var payload ZoomWebhookPayload
+	payload.Event = event
+	payload.EventTS = eventTS
+	payload.Payload.AccountID = accountID
+	payload.Payload.Object.UUID = meetingUUID
+	payload.Payload.Object.ID = "123"
+	payload.Payload.Object.Topic = "Topic " + meetingUUID
+	payload.Payload.Object.Participant.UserID = userID
+	payload.Payload.Object.Participant.UserName = userName
+	return payload
+}
+
+// signedWebhook builds a webhook request signed with testSecret and sent at the given time
+func signedWebhook(t *testing.T, payload ZoomWebhookPayload, sent time.Time) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := strconv.FormatInt(sent.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte("v0:" + timestamp + ":" + string(body)))
+
+	r := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+	r.Header.Set("x-zm-request-timestamp", timestamp)
+	r.Header.Set("x-zm-signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// sendWebhook delivers a signed webhook to the handlers and returns the status code
+func sendWebhook(t *testing.T, h *handlers, payload ZoomWebhookPayload) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.webhookHandler(w, signedWebhook(t, payload, clock()), nil)
+	return w.Code
+}
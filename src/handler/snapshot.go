@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// snapshotMagic starts every snapshot file and names its format version
+const snapshotMagic = "ZPS1"
+
+var (
+	// snapshotFile receives the encrypted state of live meetings, empty if snapshots are disabled. See Config.
+	snapshotFile string
+	// snapshotInterval is how often a checkpoint is written for crash recovery
+	snapshotInterval = time.Minute
+	// snapshotMutex serializes writing the snapshot file
+	snapshotMutex sync.Mutex
+)
+
+// snapshotContent is the decrypted content of a snapshot file
+type snapshotContent struct {
+	WrittenAt time.Time                  `json:"written_at"`
+	Accounts  map[string]json.RawMessage `json:"accounts"` // Key: AccountID, Value: Meetings by UUID
+}
+
+// SaveSnapshot writes the meetings of all accounts to the snapshot file if snapshots are enabled.
+// Accounts that keep names in memory only and meetings past their retention are left out.
+func SaveSnapshot(store Store) error {
+	if snapshotFile == "" {
+		return nil
+	}
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+
+	now := clock()
+	state := snapshotContent{WrittenAt: now, Accounts: make(map[string]json.RawMessage)}
+	for _, accountID := range store.Accounts() {
+		policy := retentionFor(accountID)
+		if policy.MemoryOnly {
+			continue
+		}
+		// Meetings are encoded within View, as they keep changing afterwards
+		var encoded []byte
+		var err error
+		store.View(accountID, func(meetings map[string]*MeetingData) {
+			kept := make(map[string]*MeetingData)
+			for uuid, meeting := range meetings {
+				if !policy.expired(meeting, now) {
+					kept[uuid] = meeting
+				}
+			}
+			if len(kept) > 0 {
+				encoded, err = json.Marshal(kept)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode meetings of account %s: %v", accountID, err)
+		}
+		if encoded != nil {
+			state.Accounts[accountID] = encoded
+		}
+	}
+	plain, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %v", err)
+	}
+
+	sealed, err := sealSnapshot(plain)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(snapshotFile, sealed)
+}
+
+// restoreSnapshot loads the meetings saved by SaveSnapshot. The current retention policies apply,
+// so meetings that expired in the meantime and accounts deleted or switched to memory only are skipped.
+func restoreSnapshot(store Store) error {
+	if snapshotFile == "" {
+		return nil
+	}
+	sealed, err := os.ReadFile(snapshotFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	plain, err := openSnapshot(sealed)
+	if err != nil {
+		return err
+	}
+	var state snapshotContent
+	if err := json.Unmarshal(plain, &state); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %v", err)
+	}
+
+	now := clock()
+	restored := 0
+	for accountID, encoded := range state.Accounts {
+		// A deleted account has no policy anymore, its meetings are dropped with it
+		policy, err := loadRetention(accountID)
+		if err != nil || policy.MemoryOnly {
+			continue
+		}
+		var meetings map[string]*MeetingData
+		if err := json.Unmarshal(encoded, &meetings); err != nil {
+			return fmt.Errorf("failed to decode meetings of account %s: %v", accountID, err)
+		}
+		ensureAccountInitialized(accountID)
+		err = store.Update(accountID, func(current map[string]*MeetingData) {
+			for uuid, meeting := range meetings {
+				if meeting == nil || policy.expired(meeting, now) {
+					continue
+				}
+				// The database of the SQLite store may hold a newer state than the snapshot. Going
+				// back to an older sequence number would make viewers ignore the next updates.
+				if existing := current[uuid]; existing != nil && !existing.olderThan(meeting) {
+					continue
+				}
+				meeting.ensureMaps()
+				current[uuid] = meeting
+				restored++
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore meetings of account %s: %v", accountID, err)
+		}
+	}
+	log.Printf("Restored %d meetings from snapshot written at %s", restored, state.WrittenAt.Format(time.RFC3339))
+	return nil
+}
+
+// olderThan reports whether the meeting has seen fewer updates than the other one
+func (m *MeetingData) olderThan(other *MeetingData) bool {
+	if m.Seq != other.Seq {
+		return m.Seq < other.Seq
+	}
+	return m.LastUpdated.Before(other.LastUpdated)
+}
+
+// ensureMaps replaces maps that were empty when the meeting was encoded
+func (m *MeetingData) ensureMaps() {
+	if m.Participants == nil {
+		m.Participants = make(map[string]*Participant)
+	}
+	if m.Waiting == nil {
+		m.Waiting = make(map[string]string)
+	}
+	if m.Rooms == nil {
+		m.Rooms = make(map[string]string)
+	}
+	if m.Applied == nil {
+		m.Applied = make(map[string]EventStamp)
+	}
+}
+
+// rewriteSnapshot saves the snapshot right away, so data that must no longer be kept does not wait for the next checkpoint
+func rewriteSnapshot(store Store) {
+	if err := SaveSnapshot(store); err != nil {
+		log.Printf("Failed to write snapshot: %v", err)
+	}
+}
+
+// checkpointSnapshots periodically saves the snapshot, so that a crash loses at most one interval
+func checkpointSnapshots(store Store) {
+	for {
+		time.Sleep(snapshotInterval)
+		rewriteSnapshot(store)
+	}
+}
+
+// sealSnapshot encrypts a snapshot with AES-GCM
+func sealSnapshot(plain []byte) ([]byte, error) {
+	aead, err := snapshotCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(append([]byte(snapshotMagic), nonce...), nonce, plain, []byte(snapshotMagic)), nil
+}
+
+// openSnapshot reverses sealSnapshot
+func openSnapshot(sealed []byte) ([]byte, error) {
+	aead, err := snapshotCipher()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(sealed, []byte(snapshotMagic)) || len(sealed) < len(snapshotMagic)+aead.NonceSize() {
+		return nil, errors.New("unknown snapshot format")
+	}
+	sealed = sealed[len(snapshotMagic):]
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(snapshotMagic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt snapshot, was the server key changed? %v", err)
+	}
+	return plain, nil
+}
+
+// snapshotCipher returns the AEAD used for snapshots
+func snapshotCipher() (cipher.AEAD, error) {
+	if snapshotKey == nil {
+		return nil, errServerKeyMissing
+	}
+	block, err := aes.NewCipher(snapshotKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic replaces a file so that readers see either the old or the new content, even after a crash
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	return nil
+}
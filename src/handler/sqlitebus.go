@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Messages older than this are removed from the database. An instance falling further behind
+// misses them, its viewers notice the gap and resync.
+const busMessageTTL = time.Minute
+
+// Message kinds on the bus
+const (
+	busUpdate  = "update"
+	busAccount = "account"
+)
+
+// busMessage is one message written to the bus table
+type busMessage struct {
+	kind        string
+	accountID   string
+	meetingUUID string
+	seq         uint64
+	data        []byte // Encrypted protocol v1 and v2 messages of an update
+	disconnect  bool
+}
+
+// busPayload holds the messages of an update before encryption
+type busPayload struct {
+	Data []byte `json:"data"`
+	V2   []byte `json:"v2"`
+}
+
+// sqliteBus passes messages between instances through a table of the shared database, which every
+// instance polls. Updates of accounts that keep names in memory only are not written, so their
+// viewers only receive the changes processed by the instance they are connected to.
+type sqliteBus struct {
+	db       *sql.DB
+	instance string
+}
+
+// newSQLiteBus starts polling messages. Messages written before are skipped.
+func newSQLiteBus(db *sql.DB, instance string, pollInterval time.Duration) (*sqliteBus, error) {
+	if instance == "" {
+		random := make([]byte, 8)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		instance = hex.EncodeToString(random)
+	}
+	// Readers do not block writers in WAL mode, which several instances polling the database need
+	if _, err := db.Exec("PRAGMA journal_mode = WAL"); err != nil {
+		return nil, fmt.Errorf("failed to enable WAL mode: %v", err)
+	}
+	var lastID int64
+	if err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM bus_messages").Scan(&lastID); err != nil {
+		return nil, fmt.Errorf("failed to read bus: %v", err)
+	}
+
+	b := &sqliteBus{db: db, instance: instance}
+	go b.poll(lastID, pollInterval)
+	log.Printf("Joined the bus as instance %s", instance)
+	return b, nil
+}
+
+// Publish implements Bus
+func (b *sqliteBus) Publish(accountID, meetingUUID string, u update) {
+	publish(accountID, meetingUUID, u)
+}
+
+// Forward implements Bus. The store only forwards updates of accounts whose meetings it writes.
+func (b *sqliteBus) Forward(tx *sql.Tx, accountID, meetingUUID string, u update) error {
+	payload, err := json.Marshal(busPayload{Data: u.data, V2: u.v2})
+	if err != nil {
+		return fmt.Errorf("failed to encode bus message: %v", err)
+	}
+	sealed, err := sealStored("bus/"+accountID+"/"+meetingUUID, payload)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt bus message: %v", err)
+	}
+	if err := b.insert(tx, busMessage{kind: busUpdate, accountID: accountID, meetingUUID: meetingUUID, seq: u.seq, data: sealed}); err != nil {
+		return fmt.Errorf("failed to write bus message: %v", err)
+	}
+	return nil
+}
+
+// AccountChanged implements Bus
+func (b *sqliteBus) AccountChanged(accountID string, disconnect bool) {
+	accountChanged(accountID, disconnect)
+	b.announce(accountID, disconnect)
+}
+
+// announce tells the other instances about a change of an account
+func (b *sqliteBus) announce(accountID string, disconnect bool) {
+	if err := b.insert(b.db, busMessage{kind: busAccount, accountID: accountID, disconnect: disconnect}); err != nil {
+		log.Printf("Failed to announce change of account %s: %v", accountID, err)
+	}
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insert writes one message to the bus table
+func (b *sqliteBus) insert(e execer, m busMessage) error {
+	_, err := e.Exec("INSERT INTO bus_messages (instance, kind, account_id, meeting_uuid, seq, data, disconnect, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		b.instance, m.kind, m.accountID, m.meetingUUID, m.seq, m.data, m.disconnect, time.Now().Unix())
+	return err
+}
+
+// poll delivers the messages of other instances and removes expired ones
+func (b *sqliteBus) poll(lastID int64, interval time.Duration) {
+	lastPrune := time.Now()
+	for {
+		time.Sleep(interval)
+
+		var err error
+		if lastID, err = b.receive(lastID); err != nil {
+			log.Printf("Failed to read bus: %v", err)
+		}
+		if time.Since(lastPrune) > busMessageTTL {
+			lastPrune = time.Now()
+			if _, err := b.db.Exec("DELETE FROM bus_messages WHERE created_at < ?", time.Now().Add(-busMessageTTL).Unix()); err != nil {
+				log.Printf("Failed to remove expired bus messages: %v", err)
+			}
+		}
+	}
+}
+
+// receive delivers the messages after lastID and returns the ID of the last one
+func (b *sqliteBus) receive(lastID int64) (int64, error) {
+	rows, err := b.db.Query("SELECT id, instance, kind, account_id, meeting_uuid, seq, data, disconnect FROM bus_messages WHERE id > ? ORDER BY id", lastID)
+	if err != nil {
+		return lastID, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var instance string
+		var m busMessage
+		if err := rows.Scan(&id, &instance, &m.kind, &m.accountID, &m.meetingUUID, &m.seq, &m.data, &m.disconnect); err != nil {
+			return lastID, err
+		}
+		lastID = id
+		if instance != b.instance {
+			b.deliver(m)
+		}
+	}
+	return lastID, rows.Err()
+}
+
+// deliver applies a message of another instance
+func (b *sqliteBus) deliver(m busMessage) {
+	switch m.kind {
+	case busAccount:
+		accountChanged(m.accountID, m.disconnect)
+	case busUpdate:
+		plain, err := openStored("bus/"+m.accountID+"/"+m.meetingUUID, m.data)
+		if err != nil {
+			log.Printf("Failed to decrypt bus message: %v", err)
+			return
+		}
+		var payload busPayload
+		if err := json.Unmarshal(plain, &payload); err != nil {
+			log.Printf("Failed to decode bus message: %v", err)
+			return
+		}
+		publish(m.accountID, m.meetingUUID, update{seq: m.seq, data: payload.Data, v2: payload.V2})
+	}
+}
@@ -0,0 +1,321 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+)
+
+// sqliteStore keeps meetings in memory like memoryStore and writes every change to the database,
+// so they survive restarts and crashes. Meetings are encrypted, and meetings of accounts that keep
+// names in memory only are never written.
+//
+// If the database is shared by several instances, every access first reloads the meetings other
+// instances changed. Updates run in a transaction that holds the database's write lock, so the
+// instances change meetings one after another and keep their sequence numbers consistent.
+type sqliteStore struct {
+	*memoryStore
+	db     *sql.DB
+	shared bool
+}
+
+// newSQLiteStore creates the store and loads the meetings saved in the database
+func newSQLiteStore(db *sql.DB, shared bool) (*sqliteStore, error) {
+	s := &sqliteStore{memoryStore: newMemoryStore(), db: db, shared: shared}
+	rows, err := db.Query("SELECT account_id, meeting_uuid, version, data FROM meetings")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load meetings: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var accountID, meetingUUID string
+		var version int64
+		var sealed []byte
+		if err := rows.Scan(&accountID, &meetingUUID, &version, &sealed); err != nil {
+			return nil, fmt.Errorf("failed to load meetings: %v", err)
+		}
+		account := s.accounts[accountID]
+		if account == nil {
+			account = &accountMeetings{meetings: make(map[string]*MeetingData)}
+			s.accounts[accountID] = account
+		}
+		if err := account.load(accountID, meetingUUID, version, sealed); err != nil {
+			log.Printf("Skipping meeting %s of account %s: %v", meetingUUID, accountID, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load meetings: %v", err)
+	}
+	return s, nil
+}
+
+// View implements Store
+func (s *sqliteStore) View(accountID string, fn func(meetings map[string]*MeetingData)) {
+	if !s.shared {
+		s.memoryStore.View(accountID, fn)
+		return
+	}
+	// Reloading changes the cached meetings, which needs the mutex for writing
+	account := s.lock(accountID, true)
+	defer account.Unlock()
+	if err := s.refresh(s.db, accountID, account); err != nil {
+		log.Printf("Failed to reload meetings of account %s: %v", accountID, err)
+	}
+	fn(account.meetings)
+}
+
+// Update implements Store
+func (s *sqliteStore) Update(accountID string, fn func(meetings map[string]*MeetingData)) error {
+	account := s.lock(accountID, true)
+	defer account.Unlock()
+	defer func() {
+		if len(account.meetings) == 0 && len(account.saved) == 0 {
+			s.remove(accountID, account)
+		}
+	}()
+
+	// The transaction starts immediately, waiting for other instances to finish their updates
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save meetings: %v", err)
+	}
+	defer tx.Rollback()
+	if s.shared {
+		if err := s.refresh(tx, accountID, account); err != nil {
+			return err
+		}
+	}
+	// fn changes a copy, so that the cached meetings and the viewers stay in line with the
+	// database if saving fails
+	meetings := cloneMeetings(account.meetings)
+	before := maps.Clone(meetings)
+	fn(meetings)
+	saved, versions, err := s.save(tx, accountID, account, meetings)
+	if err != nil {
+		return err
+	}
+	// Meetings that are not written are not passed to other instances either
+	if saved != nil {
+		if err := forwardChanges(tx, accountID, before, meetings); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save meetings: %v", err)
+	}
+	account.meetings = meetings
+	account.saved = saved
+	account.versions = versions
+	publishChanges(accountID, before, meetings)
+	return nil
+}
+
+// Forget implements Store
+func (s *sqliteStore) Forget(accountID string) error {
+	if err := s.memoryStore.Forget(accountID); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM meetings WHERE account_id = ?", accountID)
+	return err
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// refresh replaces the cached meetings of an account that other instances changed or deleted.
+// The caller must hold the account mutex for writing.
+func (s *sqliteStore) refresh(q querier, accountID string, account *accountMeetings) error {
+	rows, err := q.Query("SELECT meeting_uuid, version, data FROM meetings WHERE account_id = ?", accountID)
+	if err != nil {
+		return fmt.Errorf("failed to reload meetings: %v", err)
+	}
+	defer rows.Close()
+
+	stored := make(map[string]bool)
+	for rows.Next() {
+		var meetingUUID string
+		var version int64
+		var sealed []byte
+		if err := rows.Scan(&meetingUUID, &version, &sealed); err != nil {
+			return fmt.Errorf("failed to reload meetings: %v", err)
+		}
+		stored[meetingUUID] = true
+		if _, cached := account.meetings[meetingUUID]; cached && account.versions[meetingUUID] == version {
+			continue
+		}
+		if err := account.load(accountID, meetingUUID, version, sealed); err != nil {
+			log.Printf("Skipping meeting %s of account %s: %v", meetingUUID, accountID, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to reload meetings: %v", err)
+	}
+
+	// Meetings that were saved before but are gone now were removed by another instance
+	for meetingUUID := range account.saved {
+		if !stored[meetingUUID] {
+			delete(account.meetings, meetingUUID)
+			delete(account.saved, meetingUUID)
+			delete(account.versions, meetingUUID)
+		}
+	}
+	return nil
+}
+
+// load decrypts a meeting read from the database and caches it. The updates kept for resuming
+// viewers remain only if the meeting did not change in between, as they must not have gaps.
+func (a *accountMeetings) load(accountID, meetingUUID string, version int64, sealed []byte) error {
+	data, err := openStored(accountID+"/"+meetingUUID, sealed)
+	if err != nil {
+		return err
+	}
+	var meeting MeetingData
+	if err := json.Unmarshal(data, &meeting); err != nil {
+		return err
+	}
+	meeting.ensureMaps()
+	if previous, exists := a.meetings[meetingUUID]; exists && previous.Seq == meeting.Seq {
+		meeting.history = previous.history
+	}
+
+	if a.saved == nil {
+		a.saved = make(map[string][]byte)
+		a.versions = make(map[string]int64)
+	}
+	a.meetings[meetingUUID] = &meeting
+	a.saved[meetingUUID] = data
+	a.versions[meetingUUID] = version
+	return nil
+}
+
+// save writes the meetings of an account that changed since they were last written and deletes
+// the removed ones. It returns the meetings as written and their versions, to be kept once the
+// transaction is committed. The caller must hold the account mutex.
+func (s *sqliteStore) save(tx *sql.Tx, accountID string, account *accountMeetings, meetings map[string]*MeetingData) (map[string][]byte, map[string]int64, error) {
+	// The policy is read from the database, as another instance may have changed it. Meetings of
+	// deleted accounts are not written either.
+	policy, err := loadRetention(accountID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, fmt.Errorf("failed to read retention policy: %v", err)
+	}
+	if err != nil || policy.MemoryOnly {
+		if len(account.saved) > 0 {
+			if _, err := tx.Exec("DELETE FROM meetings WHERE account_id = ?", accountID); err != nil {
+				return nil, nil, fmt.Errorf("failed to delete meetings: %v", err)
+			}
+		}
+		return nil, nil, nil
+	}
+
+	saved := make(map[string][]byte, len(meetings))
+	versions := make(map[string]int64, len(meetings))
+	for meetingUUID, meeting := range meetings {
+		data, err := json.Marshal(meeting)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode meeting %s: %v", meetingUUID, err)
+		}
+		saved[meetingUUID] = data
+		versions[meetingUUID] = account.versions[meetingUUID]
+		if bytes.Equal(account.saved[meetingUUID], data) {
+			continue
+		}
+		sealed, err := sealStored(accountID+"/"+meetingUUID, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		version := account.versions[meetingUUID] + 1
+		_, err = tx.Exec("INSERT INTO meetings (account_id, meeting_uuid, version, data) VALUES (?, ?, ?, ?) ON CONFLICT (account_id, meeting_uuid) DO UPDATE SET version = excluded.version, data = excluded.data",
+			accountID, meetingUUID, version, sealed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to save meeting %s: %v", meetingUUID, err)
+		}
+		versions[meetingUUID] = version
+	}
+	for meetingUUID := range account.saved {
+		if _, exists := meetings[meetingUUID]; exists {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM meetings WHERE account_id = ? AND meeting_uuid = ?", accountID, meetingUUID); err != nil {
+			return nil, nil, fmt.Errorf("failed to delete meeting %s: %v", meetingUUID, err)
+		}
+	}
+	return saved, versions, nil
+}
+
+// cloneMeetings copies meetings deeply enough that changing the copy leaves the originals intact
+func cloneMeetings(meetings map[string]*MeetingData) map[string]*MeetingData {
+	cloned := make(map[string]*MeetingData, len(meetings))
+	for meetingUUID, meeting := range meetings {
+		cloned[meetingUUID] = meeting.clone()
+	}
+	return cloned
+}
+
+// clone copies a meeting. The updates and draws are shared, as they never change once recorded.
+func (m *MeetingData) clone() *MeetingData {
+	c := *m
+	c.Participants = make(map[string]*Participant, len(m.Participants))
+	for key, participant := range m.Participants {
+		p := *participant
+		p.Sessions = slices.Clone(participant.Sessions)
+		c.Participants[key] = &p
+	}
+	c.Waiting = maps.Clone(m.Waiting)
+	c.Rooms = maps.Clone(m.Rooms)
+	c.RoomOrder = slices.Clone(m.RoomOrder)
+	c.Applied = maps.Clone(m.Applied)
+	c.Draws = slices.Clone(m.Draws)
+	c.RaffleSeed = slices.Clone(m.RaffleSeed)
+	c.history = slices.Clone(m.history)
+	c.unpublished = nil
+	return &c
+}
+
+// sealStored encrypts data written to the shared database, bound to the given context such as
+// the account and meeting it belongs to
+func sealStored(context string, data []byte) ([]byte, error) {
+	aead, err := storeCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, []byte(context)), nil
+}
+
+// openStored reverses sealStored
+func openStored(context string, sealed []byte) ([]byte, error) {
+	aead, err := storeCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("stored data too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(context))
+}
+
+// storeCipher returns the AEAD used for meetings and messages in the database
+func storeCipher() (cipher.AEAD, error) {
+	if storeKey == nil {
+		return nil, errServerKeyMissing
+	}
+	block, err := aes.NewCipher(storeKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
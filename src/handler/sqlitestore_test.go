@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStoreFailedSave(t *testing.T) {
+	db := newTestDB(t)
+	addTestAccount(t, db, "acc", "viewerpassword123", defaultRetention)
+	store, err := newSQLiteStore(db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handlers{store: store}
+	v := newViewer("ws", "m1", protocolV2)
+	subscribe(store, "acc", "", v)
+
+	ts := time.Now().UnixMilli()
+	if code := sendWebhook(t, h, webhookPayload("acc", "meeting.participant_joined", "m1", "u1", "Alice", ts)); code != http.StatusOK {
+		t.Fatalf("first webhook: got %d", code)
+	}
+	<-v.queue
+
+	// Writing meetings fails until the trigger is dropped
+	if _, err := db.Exec("CREATE TRIGGER fail_update BEFORE UPDATE ON meetings BEGIN SELECT RAISE(ABORT, 'disk I/O error'); END"); err != nil {
+		t.Fatal(err)
+	}
+	if code := sendWebhook(t, h, webhookPayload("acc", "meeting.participant_joined", "m1", "u2", "Bob", ts+1)); code != http.StatusInternalServerError {
+		t.Fatalf("failing webhook: got %d", code)
+	}
+	store.View("acc", func(meetings map[string]*MeetingData) {
+		if meeting := meetings["m1"]; len(meeting.Participants) != 1 || meeting.Seq != 1 {
+			t.Errorf("cached meeting changed by a failed save: %d participants, seq %d", len(meeting.Participants), meeting.Seq)
+		}
+	})
+	if len(v.queue) != 0 {
+		t.Errorf("viewer received %d updates of a failed save", len(v.queue))
+	}
+
+	// Zoom retries the webhook, which is applied once, and the viewer sees no gap
+	if _, err := db.Exec("DROP TRIGGER fail_update"); err != nil {
+		t.Fatal(err)
+	}
+	if code := sendWebhook(t, h, webhookPayload("acc", "meeting.participant_joined", "m1", "u2", "Bob", ts+1)); code != http.StatusOK {
+		t.Fatalf("retried webhook: got %d", code)
+	}
+	if u := <-v.queue; u.seq != 2 {
+		t.Errorf("viewer received seq %d, want 2", u.seq)
+	}
+	reloaded, err := newSQLiteStore(db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.View("acc", func(meetings map[string]*MeetingData) {
+		if meeting := meetings["m1"]; len(meeting.Participants) != 2 || meeting.Seq != 2 {
+			t.Errorf("saved meeting: %d participants, seq %d", len(meeting.Participants), meeting.Seq)
+		}
+	})
+}
+
+func TestSnapshotKeepsNewerMeetings(t *testing.T) {
+	db := newTestDB(t)
+	addTestAccount(t, db, "acc", "viewerpassword123", defaultRetention)
+	store, err := newSQLiteStore(db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := snapshotFile
+	snapshotFile = filepath.Join(t.TempDir(), "snapshot.bin")
+	t.Cleanup(func() { snapshotFile = previous })
+	h := &handlers{store: store}
+
+	ts := time.Now().UnixMilli()
+	for _, payload := range []ZoomWebhookPayload{
+		webhookPayload("acc", "meeting.participant_joined", "m1", "u1", "Alice", ts),
+		webhookPayload("acc", "meeting.participant_joined", "m2", "u1", "Alice", ts),
+	} {
+		if code := sendWebhook(t, h, payload); code != http.StatusOK {
+			t.Fatalf("webhook %s: got %d", payload.Event, code)
+		}
+	}
+	if err := SaveSnapshot(store); err != nil {
+		t.Fatal(err)
+	}
+	// After the snapshot, one meeting changes and the other is lost from the database
+	if code := sendWebhook(t, h, webhookPayload("acc", "meeting.participant_joined", "m1", "u2", "Bob", ts+1)); code != http.StatusOK {
+		t.Fatalf("webhook after the snapshot: got %d", code)
+	}
+	if _, err := db.Exec("DELETE FROM meetings WHERE meeting_uuid = ?", "m2"); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := newSQLiteStore(db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreSnapshot(restarted); err != nil {
+		t.Fatal(err)
+	}
+	restarted.View("acc", func(meetings map[string]*MeetingData) {
+		if meeting := meetings["m1"]; meeting == nil || len(meeting.Participants) != 2 || meeting.Seq != 2 {
+			t.Errorf("newer meeting was replaced by the snapshot: %+v", meeting)
+		}
+		if meeting := meetings["m2"]; meeting == nil || meeting.Seq != 1 {
+			t.Errorf("missing meeting was not restored: %+v", meeting)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Interval of comments that keep idle streams open through proxies
+const sseHeartbeat = 25 * time.Second
+
+// writeEvent writes one update in the event stream format
+func writeEvent(w http.ResponseWriter, v *viewer, u update) error {
+	if v.meetingUUID != "" {
+		if _, err := fmt.Fprintf(w, "id: %d\n", u.seq); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", u.payload(v.version))
+	return err
+}
+
+// sseHandler streams the same updates as the WebSocket for clients behind proxies that break WebSockets
+func (h *handlers) sseHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	accountID, authenticated := sessionAccount(r)
+	if !authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rc := http.NewResponseController(w)
+
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = r.URL.Query().Get("since")
+	}
+	v := newViewer("sse", r.URL.Query().Get("meeting"), protocolVersion(r))
+	subscribe(h.store, accountID, since, v)
+	defer unsubscribe(accountID, v)
+	if err := revealDueDraws(h.store, accountID, clock()); err != nil {
+		log.Printf("Failed to reveal draws of account %s: %v", accountID, err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	// A viewer that stops reading must not keep its goroutine blocked forever, so every write has
+	// a deadline. It is cleared while waiting, the next update may take longer than the timeout.
+	send := func(write func() error) bool {
+		rc.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		defer rc.SetWriteDeadline(time.Time{})
+		if err := write(); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	backlog := func() error {
+		if _, err := fmt.Fprint(w, "retry: 3000\n\n"); err != nil {
+			return err
+		}
+		for _, u := range v.backlog {
+			if err := writeEvent(w, v, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !send(backlog) {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-v.done:
+			return
+		case u := <-v.queue:
+			if !send(func() error { return writeEvent(w, v, u) }) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(func() error {
+				_, err := fmt.Fprint(w, ": keepalive\n\n")
+				return err
+			}) {
+				return
+			}
+		}
+		v.touch()
+	}
+}
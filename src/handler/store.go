@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// Store backends, see Config
+const (
+	storeMemory = "memory"
+	storeSQLite = "sqlite"
+)
+
+// Store keeps the meetings of all accounts. The functions passed to View and Update run while the
+// account is locked, so changes and the broadcasts announcing them stay in order. They must not
+// call the store again or keep references to the meetings after returning.
+type Store interface {
+	// View calls fn with the meetings of an account, which it must not modify
+	View(accountID string, fn func(meetings map[string]*MeetingData))
+	// Update calls fn with the meetings of an account. fn may change, add and delete meetings, the
+	// store keeps the result when it returns. If it returns an error, the changes and the updates
+	// broadcast by fn are dropped.
+	Update(accountID string, fn func(meetings map[string]*MeetingData)) error
+	// Accounts lists the accounts the store holds meetings for
+	Accounts() []string
+	// Forget removes all meetings of an account
+	Forget(accountID string) error
+}
+
+// NewStore creates the store selected in the configuration. The server key must be loaded before.
+func NewStore(cfg *Config, db *sql.DB) (Store, error) {
+	switch cfg.Store {
+	case storeMemory:
+		return newMemoryStore(), nil
+	case storeSQLite:
+		return newSQLiteStore(db, cfg.Bus == busSQLite)
+	}
+	return nil, fmt.Errorf("unknown store %q", cfg.Store)
+}
+
+// memoryStore keeps meetings in memory only, they are lost when the server stops
+type memoryStore struct {
+	mu       sync.Mutex
+	accounts map[string]*accountMeetings
+}
+
+// accountMeetings holds the meetings of one account together with the mutex guarding them
+type accountMeetings struct {
+	sync.RWMutex
+	meetings map[string]*MeetingData // Key: Meeting UUID
+	saved    map[string][]byte       // Key: Meeting UUID, Value: Meeting as last written by sqliteStore
+	versions map[string]int64        // Key: Meeting UUID, Value: Version of the meeting in the database
+	removed  bool                    // Set when the account was dropped from the store, whoever waited for the mutex must start over
+}
+
+// newMemoryStore creates an empty store
+func newMemoryStore() *memoryStore {
+	return &memoryStore{accounts: make(map[string]*accountMeetings)}
+}
+
+// lock returns the locked meetings of an account, creating them if necessary
+func (s *memoryStore) lock(accountID string, write bool) *accountMeetings {
+	for {
+		s.mu.Lock()
+		account, exists := s.accounts[accountID]
+		if !exists {
+			account = &accountMeetings{meetings: make(map[string]*MeetingData)}
+			s.accounts[accountID] = account
+		}
+		s.mu.Unlock()
+
+		if write {
+			account.Lock()
+			if !account.removed {
+				return account
+			}
+			account.Unlock()
+		} else {
+			account.RLock()
+			if !account.removed {
+				return account
+			}
+			account.RUnlock()
+		}
+	}
+}
+
+// remove drops an account from the store. The caller must hold its mutex for writing.
+func (s *memoryStore) remove(accountID string, account *accountMeetings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accounts[accountID] == account {
+		delete(s.accounts, accountID)
+	}
+	account.removed = true
+}
+
+// View implements Store
+func (s *memoryStore) View(accountID string, fn func(meetings map[string]*MeetingData)) {
+	account := s.lock(accountID, false)
+	defer account.RUnlock()
+	fn(account.meetings)
+}
+
+// Update implements Store. Accounts left without meetings are dropped.
+func (s *memoryStore) Update(accountID string, fn func(meetings map[string]*MeetingData)) error {
+	account := s.lock(accountID, true)
+	defer account.Unlock()
+	before := maps.Clone(account.meetings)
+	fn(account.meetings)
+	publishChanges(accountID, before, account.meetings)
+	if len(account.meetings) == 0 {
+		s.remove(accountID, account)
+	}
+	return nil
+}
+
+// Accounts implements Store
+func (s *memoryStore) Accounts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accountIDs := make([]string, 0, len(s.accounts))
+	for accountID := range s.accounts {
+		accountIDs = append(accountIDs, accountID)
+	}
+	return accountIDs
+}
+
+// Forget implements Store
+func (s *memoryStore) Forget(accountID string) error {
+	account := s.lock(accountID, true)
+	defer account.Unlock()
+	s.remove(accountID, account)
+	return nil
+}
@@ -6,69 +6,41 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"log"
 	"net/http"
-	"sort"
-	"sync"
+	"strings"
 	"time"
 )
 
+// allowedOrigins lists the normalized origins of pages that may open a WebSocket, see Config
+var allowedOrigins map[string]bool
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
-		origin := r.Header.Get("Origin")
-		return origin == "http://localhost:8080" || origin == "https://zoom.8bj.de"
+		return allowedOrigins[strings.ToLower(r.Header.Get("Origin"))]
 	},
 }
 
-type conndata struct {
-	bool
-	lastKeepalive time.Time
-}
-
-// Map of accountID to active WebSocket connections
-var wsConnections = struct {
-	sync.RWMutex
-	conns map[string]map[*websocket.Conn]conndata
-}{conns: make(map[string]map[*websocket.Conn]conndata)}
-
-// Add or update connection with keepalive
-func addConnection(accountID string, conn *websocket.Conn) {
-	wsConnections.Lock()
-	defer wsConnections.Unlock()
-	if wsConnections.conns[accountID] == nil {
-		wsConnections.conns[accountID] = make(map[*websocket.Conn]conndata)
-	}
-	wsConnections.conns[accountID][conn] = conndata{true, time.Now()}
-}
+// Time allowed to write a message to a viewer
+const wsWriteTimeout = 10 * time.Second
 
-// Remove connection
-func removeConnection(accountID string, conn *websocket.Conn) {
-	wsConnections.Lock()
-	defer wsConnections.Unlock()
-	if conns, ok := wsConnections.conns[accountID]; ok {
-		delete(conns, conn)
-		if len(conns) == 0 {
-			delete(wsConnections.conns, accountID)
-		}
-	}
-}
+// Interval of pings, well below viewerTimeout so that a single lost pong does not disconnect the viewer
+const wsPingInterval = 25 * time.Second
 
-// Broadcast sorted participant list to connected clients for an account
-func broadcastParticipants(accountID string, participants map[string]string) {
-	names := make([]string, 0, len(participants))
-	for _, name := range participants {
-		names = append(names, name)
-	}
-	sort.Strings(names)
+// broadcastMeetingEnded tells the viewers that the meeting ended. Protocol v1 receives the
+// remaining participants as a bare list.
+func broadcastMeetingEnded(accountID, meetingUUID string, meeting *MeetingData, names []string) {
 	data, err := json.Marshal(names)
 	if err != nil {
 		log.Printf("Error marshaling participants: %v", err)
 		return
 	}
 
-	broadcastData(accountID, data)
+	broadcastData(accountID, meetingUUID, meeting, data, map[string]interface{}{
+		"type": "meeting_ended",
+	})
 }
 
 // broadcastJoined broadcasts a single participant joined event
-func broadcastJoined(accountID string, participantName string) {
+func broadcastJoined(accountID, meetingUUID string, meeting *MeetingData, id, participantName string) {
 	message := map[string]string{
 		"action": "add",
 		"name":   participantName,
@@ -76,15 +48,18 @@ func broadcastJoined(accountID string, participantName string) {
 	data, err := json.Marshal(message)
 	if err != nil {
 		log.Printf("Error marshaling joined participant: %v", err)
-		wsConnections.RUnlock()
 		return
 	}
 
-	broadcastData(accountID, data)
+	broadcastData(accountID, meetingUUID, meeting, data, map[string]interface{}{
+		"type": "join",
+		"id":   id,
+		"name": participantName,
+	})
 }
 
 // broadcastLeft broadcasts a single participant left event
-func broadcastLeft(accountID string, participantName string) {
+func broadcastLeft(accountID, meetingUUID string, meeting *MeetingData, id, participantName string) {
 	message := map[string]string{
 		"action": "remove",
 		"name":   participantName,
@@ -92,62 +67,127 @@ func broadcastLeft(accountID string, participantName string) {
 	data, err := json.Marshal(message)
 	if err != nil {
 		log.Printf("Error marshaling left participant: %v", err)
-		wsConnections.RUnlock()
 		return
 	}
 
-	broadcastData(accountID, data)
+	broadcastData(accountID, meetingUUID, meeting, data, map[string]interface{}{
+		"type": "leave",
+		"id":   id,
+	})
 }
 
-func broadcastData(accountID string, data []byte) {
-	wsConnections.RLock()
-	conns := wsConnections.conns[accountID]
-	if conns == nil {
-		wsConnections.RUnlock()
+// broadcastWaitingJoined broadcasts a participant entering the waiting room
+func broadcastWaitingJoined(accountID, meetingUUID string, meeting *MeetingData, id, participantName string) {
+	message := map[string]string{
+		"action": "wait_add",
+		"name":   participantName,
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling waiting participant: %v", err)
 		return
 	}
 
-	var toRemove []*websocket.Conn
-	now := time.Now()
-	for conn, info := range conns {
-		if now.Sub(info.lastKeepalive) > time.Minute {
-			toRemove = append(toRemove, conn)
-			continue
-		}
-		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Printf("Error writing to websocket: %v", err)
-			toRemove = append(toRemove, conn)
-		} else {
-			info.lastKeepalive = now
-			conns[conn] = info
-		}
+	broadcastData(accountID, meetingUUID, meeting, data, map[string]interface{}{
+		"type": "wait_join",
+		"id":   id,
+		"name": participantName,
+	})
+}
+
+// broadcastWaitingLeft broadcasts a participant leaving the waiting room
+func broadcastWaitingLeft(accountID, meetingUUID string, meeting *MeetingData, id, participantName string) {
+	message := map[string]string{
+		"action": "wait_remove",
+		"name":   participantName,
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling waiting participant: %v", err)
+		return
 	}
-	wsConnections.RUnlock()
 
-	wsConnections.Lock()
-	for _, conn := range toRemove {
-		conn.Close()
-		delete(wsConnections.conns[accountID], conn)
+	broadcastData(accountID, meetingUUID, meeting, data, map[string]interface{}{
+		"type": "wait_leave",
+		"id":   id,
+	})
+}
+
+// broadcastRooms broadcasts the current breakout room assignment
+func broadcastRooms(accountID, meetingUUID string, meeting *MeetingData) {
+	message := map[string]interface{}{
+		"action": "rooms",
+		"rooms":  meeting.breakoutRooms(),
 	}
-	if len(wsConnections.conns[accountID]) == 0 {
-		delete(wsConnections.conns, accountID)
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling breakout rooms: %v", err)
+		return
 	}
-	wsConnections.Unlock()
+
+	broadcastData(accountID, meetingUUID, meeting, data, map[string]interface{}{
+		"type":  "rooms",
+		"rooms": meeting.roomEntries(),
+	})
 }
 
-// WebSocket handler endpoint
-func wsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	viewerPassword := r.URL.Query().Get("password")
-	if viewerPassword == "" {
-		http.Error(w, "Missing password", http.StatusUnauthorized)
+// broadcastRaffleStart tells the viewers to start the raffle animation, the result follows at RevealAt
+func broadcastRaffleStart(accountID, meetingUUID string, meeting *MeetingData, d *Draw) {
+	duration := d.RevealAt.Sub(d.DrawnAt).Milliseconds()
+	message := map[string]interface{}{
+		"action":      "raffle_start",
+		"draw":        d.Number,
+		"duration_ms": duration,
+		"reveal_at":   d.RevealAt,
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling raffle start: %v", err)
 		return
 	}
 
-	appState.PasswordMutex.RLock()
-	accountID, ok := appState.PasswordToAccountID[viewerPassword]
-	appState.PasswordMutex.RUnlock()
-	if !ok {
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+	broadcastData(accountID, meetingUUID, meeting, data, map[string]interface{}{
+		"type":        "raffle_start",
+		"draw":        d.Number,
+		"duration_ms": duration,
+		"reveal_at":   d.RevealAt,
+	})
+}
+
+// broadcastRaffleResult reveals the winners of a draw. Protocol v2 also receives the proof.
+func broadcastRaffleResult(accountID, meetingUUID string, meeting *MeetingData, d *Draw) {
+	names := make([]string, len(d.Winners))
+	for i, winner := range d.Winners {
+		names[i] = winner.Name
+	}
+	message := map[string]interface{}{
+		"action":    "raffle_result",
+		"draw":      d.Number,
+		"reveal_at": d.RevealAt,
+		"winners":   names,
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling raffle result: %v", err)
+		return
+	}
+
+	broadcastData(accountID, meetingUUID, meeting, data, map[string]interface{}{
+		"type":        "raffle_result",
+		"draw":        d.Number,
+		"reveal_at":   d.RevealAt,
+		"winners":     d.Winners,
+		"commitment":  d.Commitment,
+		"seed":        d.Seed,
+		"client_seed": d.ClientSeed,
+	})
+}
+
+// WebSocket handler endpoint
+func (h *handlers) wsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	accountID, authenticated := sessionAccount(r)
+	if !authenticated {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
@@ -156,53 +196,69 @@ func wsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
-	defer conn.Close()
-
-	addConnection(accountID, conn)
-	defer removeConnection(accountID, conn)
 
-	sendCurrentParticipants(accountID, conn)
+	query := r.URL.Query()
+	v := newViewer("ws", query.Get("meeting"), protocolVersion(r))
+	subscribe(h.store, accountID, query.Get("since"), v)
+	defer unsubscribe(accountID, v)
+	// Winners still due, for example after a restart during the raffle animation, reach the viewer this way
+	if err := revealDueDraws(h.store, accountID, clock()); err != nil {
+		log.Printf("Failed to reveal draws of account %s: %v", accountID, err)
+	}
+	go writeWebSocket(conn, v)
 
+	// Pongs and messages extend the read deadline, a connection without them times out here.
+	// Pages loaded before pings were introduced still send text keepalives.
+	alive := func(string) error {
+		v.touch()
+		return conn.SetReadDeadline(time.Now().Add(viewerTimeout))
+	}
+	conn.SetPongHandler(alive)
+	alive("")
 	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
+		if _, _, err := conn.ReadMessage(); err != nil {
 			break
 		}
-		wsConnections.Lock()
-		if conns, ok := wsConnections.conns[accountID]; ok {
-			if info, ok := conns[conn]; ok {
-				info.lastKeepalive = time.Now()
-				conns[conn] = info
-			}
-		}
-		wsConnections.Unlock()
+		alive("")
 	}
 }
 
-// Helper to send current participants to a new connection
-func sendCurrentParticipants(accountID string, conn *websocket.Conn) {
-	ensureAccountInitialized(accountID)
-	appState.AccountMutexes[accountID].RLock()
-	meetings := appState.Meetings[accountID]
-	var latestMeeting *MeetingData
-	for _, meeting := range meetings {
-		if latestMeeting == nil || meeting.LastUpdated.After(latestMeeting.LastUpdated) {
-			latestMeeting = meeting
+// writeWebSocket sends the backlog and queued updates of a viewer until it is disconnected.
+// It is the only goroutine writing messages to the connection.
+func writeWebSocket(conn *websocket.Conn, v *viewer) {
+	defer conn.Close()
+
+	for _, u := range v.backlog {
+		if !writeUpdate(conn, v, u) {
+			return
 		}
 	}
-	var names []string
-	if latestMeeting != nil {
-		for _, name := range latestMeeting.Participants {
-			names = append(names, name)
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case u := <-v.queue:
+			if !writeUpdate(conn, v, u) {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case <-v.done:
+			message := websocket.FormatCloseMessage(v.closeCode, v.closeText)
+			conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteTimeout))
+			return
 		}
-		sort.Strings(names)
 	}
-	appState.AccountMutexes[accountID].RUnlock()
+}
 
-	message := map[string]interface{}{
-		"action":       "reset",
-		"participants": names,
+// writeUpdate writes one update in the viewer's protocol version and reports whether it succeeded
+func writeUpdate(conn *websocket.Conn, v *viewer, u update) bool {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := conn.WriteMessage(websocket.TextMessage, u.payload(v.version)); err != nil {
+		log.Printf("Error writing to websocket: %v", err)
+		return false
 	}
-	data, _ := json.Marshal(message)
-	conn.WriteMessage(websocket.TextMessage, data)
+	return true
 }
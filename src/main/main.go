@@ -2,41 +2,72 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 	"windowsfreak/zoom/participants/src/handler"
 )
 
+// shutdownTimeout limits how long open requests may delay the shutdown
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	db, err := handler.InitDB("./zoom_accounts.db")
+	cfg, err := handler.LoadConfig()
+	if err != nil {
+		log.Fatalf("Configuration failed: %v", err)
+	}
+
+	if err := handler.LoadServerKey(cfg.ServerKeyFile); err != nil {
+		log.Fatalf("Server key initialization failed: %v", err)
+	}
+
+	db, err := handler.InitDB(cfg.DatabasePath)
 	if err != nil {
 		log.Fatalf("Database initialization failed: %v", err)
 	}
 	defer db.Close()
 
-	server := handler.NewServer(db)
+	store, err := handler.NewStore(cfg, db)
+	if err != nil {
+		log.Fatalf("Store initialization failed: %v", err)
+	}
+
+	server, err := handler.NewServer(db, store, cfg)
+	if err != nil {
+		log.Fatalf("Server initialization failed: %v", err)
+	}
 
-	socketPath := os.Getenv("UNIX")
+	socketPath := cfg.UnixSocket
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	// stopped is closed once the shutdown is complete, as Serve returns as soon as it begins
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		<-c
 		println()
 		log.Println("Shutting down server...")
 
-		err := server.Shutdown(context.Background())
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(ctx)
 		if err != nil {
 			log.Printf("Server stopped: %s", err.Error())
 		}
+		if err := handler.SaveSnapshot(store); err != nil {
+			log.Printf("Failed to write snapshot: %v", err)
+		}
 
 		if socketPath != "" {
 			os.Remove(socketPath)
 		}
-
-		os.Exit(0)
 	}()
+	var serveErr error
 	if socketPath != "" {
 		defer os.Remove(socketPath)
 		listener, err := net.Listen("unix", socketPath)
@@ -47,9 +78,13 @@ func main() {
 			log.Printf("Could not change permissions to 0666 on unix:%s", socketPath)
 		}
 		log.Printf("Listening on unix:%s", socketPath)
-		log.Fatal(server.Serve(listener))
+		serveErr = server.Serve(listener)
 	} else {
 		log.Printf("Listening on %s", server.Addr)
-		log.Fatal(server.ListenAndServe())
+		serveErr = server.ListenAndServe()
+	}
+	if !errors.Is(serveErr, http.ErrServerClosed) {
+		log.Fatal(serveErr)
 	}
+	<-stopped
 }